@@ -0,0 +1,78 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/inwinstack/ip-assigner/pkg/backup"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	exportCommand = "export"
+	importCommand = "import"
+)
+
+func runExport(clientset kubernetes.Interface, allocator k8sutil.Allocator) error {
+	b, err := backup.Export(clientset, allocator, cfg.Keys())
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if backupFile != "" {
+		f, err := os.Create(backupFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := b.Write(w); err != nil {
+		return err
+	}
+	glog.Infof("Exported %d Namespaces and %d Services", len(b.Namespaces), len(b.Services))
+	return nil
+}
+
+func runImport(clientset kubernetes.Interface, allocator k8sutil.Allocator) error {
+	var r io.Reader = os.Stdin
+	if backupFile != "" {
+		f, err := os.Open(backupFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	b, err := backup.Read(r)
+	if err != nil {
+		return err
+	}
+
+	if err := backup.Import(clientset, allocator, cfg.Keys(), b); err != nil {
+		return err
+	}
+	glog.Infof("Imported %d Namespaces and %d Services", len(b.Namespaces), len(b.Services))
+	return nil
+}
@@ -20,9 +20,13 @@ import (
 	"context"
 	goflag "flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/inwinstack/ip-assigner/pkg/operator"
 
@@ -30,25 +34,67 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
 	"github.com/inwinstack/ip-assigner/pkg/version"
 	flag "github.com/spf13/pflag"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 var (
-	cfg        = &config.Config{}
-	kubeconfig string
-	ver        bool
+	cfg                  = &config.Config{}
+	kubeconfig           string
+	ipamKubeconfig       string
+	ipamContext          string
+	notifySecretFile     string
+	controllerWorkers    []string
+	controllerRateLimits []string
+	backupFile           string
+	ver                  bool
 )
 
 func parserFlags() {
 	flag.StringVarP(&kubeconfig, "kubeconfig", "", "", "Absolute path to the kubeconfig file.")
+	flag.StringVarP(&ipamKubeconfig, "ipam-kubeconfig", "", "", "Absolute path to the kubeconfig file of the cluster which stores Pools and IPs.")
+	flag.StringVarP(&ipamContext, "ipam-context", "", "", "The context of the IPAM kubeconfig to use.")
+	flag.StringVarP(&cfg.ClusterID, "cluster-id", "", "", "The ID of this cluster, used to scope IPs in a shared IPAM cluster.")
 	flag.IntVarP(&cfg.Threads, "threads", "", 2, "Number of worker threads used by the controller.")
+	flag.StringVarP(&cfg.AssignerClass, "assigner-class", "", "", "The class of this instance, only objects with the same assigner-class annotation are handled.")
+	flag.BoolVarP(&cfg.DefaultClass, "default-class", "", false, "Also handle the objects without an assigner-class annotation.")
+	flag.StringVarP(&cfg.AnnotationPrefix, "annotation-prefix", "", constants.DefaultAnnotationPrefix, "The prefix of the annotation and label keys.")
+	flag.StringSliceVarP(&cfg.EnabledControllers, "controllers", "", []string{"*"}, "The controllers to run, one of namespace, service, ipclaim, nat, gc or * for all.")
+	flag.StringSliceVarP(&cfg.WatchNamespaces, "watch-namespaces", "", nil, "Only watch the Services and IPClaims of these namespaces, empty to watch all namespaces.")
+	flag.StringSliceVarP(&controllerWorkers, "controller-workers", "", nil, "The worker threads of controllers as <name>=<workers>, defaults to --threads.")
+	flag.StringSliceVarP(&controllerRateLimits, "controller-rate-limits", "", nil, "The rate limits of controllers as <name>=<qps>:<burst>:<base-delay>:<max-delay>.")
+	flag.IntVarP(&cfg.MaxRetries, "max-retries", "", 0, "Number of retries before a Namespace or Service failing with a transient error is parked until it or its pool changes, 0 to retry forever.")
 	flag.IntVarP(&cfg.SyncSec, "sync-seconds", "", 30, "Seconds for syncing and retrying objects.")
 	flag.StringVarP(&cfg.PrivatePool, "private-pool", "", "default", "The default for the private pool.")
 	flag.StringVarP(&cfg.PublicPool, "public-pool", "", "internet", "The default for the public pool.")
+	flag.DurationVarP(&cfg.MigrationDrainPeriod, "migration-drain-period", "", 5*time.Minute, "How long both pools are published when the pool of a Namespace is changed.")
+	flag.BoolVarP(&cfg.StandaloneIPAM, "standalone-ipam", "", false, "Assign addresses from the pools without the IPAM operator.")
+	flag.BoolVarP(&cfg.EnableIPClaims, "enable-ipclaims", "", false, "Enable the IPClaim controller, the IPClaim CRD must be installed.")
+	flag.StringVarP(&cfg.NATNamespace, "nat-namespace", "", "kube-system", "The namespace of the NAT mapping ConfigMap.")
+	flag.StringVarP(&cfg.NATConfigMap, "nat-configmap", "", "", "The name of the NAT mapping ConfigMap, e.g. ip-assigner-nat-mappings, empty to disable.")
+	flag.StringVarP(&cfg.FirewallProvider, "firewall-provider", "", "", "The firewall provider for NAT mappings (file or http).")
+	flag.StringVarP(&cfg.FirewallTarget, "firewall-target", "", "", "The file path or URL used by the firewall provider.")
+	flag.StringVarP(&cfg.AuditFile, "audit-file", "", "", "The path of the JSON lines allocation audit log, empty to disable.")
+	flag.IntVarP(&cfg.AuditFileMaxSizeMB, "audit-file-max-size-mb", "", 100, "The size in megabytes at which the audit log is rotated.")
+	flag.IntVarP(&cfg.AuditFileMaxBackups, "audit-file-max-backups", "", 5, "Number of rotated audit logs to keep.")
+	flag.BoolVarP(&cfg.AuditHistory, "audit-history", "", false, "Record allocations as AllocationHistory objects, the CRD must be installed.")
+	flag.StringSliceVarP(&cfg.NotifyEndpoints, "notify-endpoints", "", nil, "The HTTP endpoints notified of assigned and released addresses.")
+	flag.StringVarP(&notifySecretFile, "notify-secret-file", "", "", "The file of the secret used to sign the notifications.")
+	flag.StringVarP(&cfg.NotifyQueueDir, "notify-queue-dir", "", "", "The directory which persists undelivered notifications, empty to keep them in memory.")
+	flag.DurationVarP(&cfg.GCInterval, "gc-interval", "", 10*time.Minute, "Interval of collecting orphaned IPs, 0 to disable.")
+	flag.DurationVarP(&cfg.GCGracePeriod, "gc-grace-period", "", time.Hour, "How long an IP must be orphaned before it is deleted.")
+	flag.BoolVarP(&cfg.GCDelete, "gc-delete", "", false, "Delete the orphaned IPs after the grace period instead of only reporting them.")
+	flag.StringVarP(&cfg.AdoptInventory, "adopt-inventory", "", "", "A CSV or YAML inventory of existing addresses to adopt at startup.")
+	flag.BoolVarP(&cfg.AdoptUnlabelled, "adopt-unlabelled", "", false, "Adopt the existing IPs without owner labels at startup.")
+	flag.StringVarP(&cfg.HealthAddress, "health-address", "", ":8080", "The address of the health, readiness and debug endpoints, empty to disable.")
+	flag.DurationVarP(&cfg.WedgeTimeout, "wedge-timeout", "", 5*time.Minute, "How long a worker may reconcile one object before it is reported as wedged.")
+	flag.DurationVarP(&cfg.ShutdownTimeout, "shutdown-timeout", "", 30*time.Second, "How long in-flight reconciles may finish on shutdown.")
+	flag.StringVarP(&backupFile, "file", "f", "", "The backup file of the export and import commands, defaults to stdout and stdin.")
 	flag.CommandLine.AddGoFlagSet(goflag.CommandLine)
 	flag.Parse()
 }
@@ -69,8 +115,30 @@ func restConfig(kubeconfig string) (*rest.Config, error) {
 	return cfg, nil
 }
 
+func ipamRestConfig(k8scfg *rest.Config) (*rest.Config, error) {
+	if ipamKubeconfig == "" && ipamContext == "" {
+		return k8scfg, nil
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if ipamKubeconfig != "" {
+		rules.ExplicitPath = ipamKubeconfig
+	} else if kubeconfig != "" {
+		rules.ExplicitPath = kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: ipamContext}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
 func main() {
 	defer glog.Flush()
+
+	// The first argument selects a command, the operator runs by default.
+	var command string
+	if len(os.Args) > 1 && (os.Args[1] == exportCommand || os.Args[1] == importCommand) {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	parserFlags()
 
 	if ver {
@@ -78,6 +146,23 @@ func main() {
 		os.Exit(0)
 	}
 
+	if !strings.HasSuffix(cfg.AnnotationPrefix, "/") {
+		cfg.AnnotationPrefix += "/"
+	}
+	cfg.SetAnnotationPrefix(cfg.AnnotationPrefix)
+
+	if err := cfg.ParseControllers(controllerWorkers, controllerRateLimits); err != nil {
+		glog.Fatalf("Invalid controller flags: %s", err.Error())
+	}
+
+	if notifySecretFile != "" {
+		secret, err := ioutil.ReadFile(notifySecretFile)
+		if err != nil {
+			glog.Fatalf("Failed to read notification secret: %s", err.Error())
+		}
+		cfg.NotifySecret = strings.TrimSpace(string(secret))
+	}
+
 	k8scfg, err := restConfig(kubeconfig)
 	if err != nil {
 		glog.Fatalf("Failed to build kubeconfig: %s", err.Error())
@@ -88,22 +173,58 @@ func main() {
 		glog.Fatalf("Failed to build Kubernetes client: %s", err.Error())
 	}
 
-	blendedclient, err := blended.NewForConfig(k8scfg)
+	dynamicclient, err := dynamic.NewForConfig(k8scfg)
+	if err != nil {
+		glog.Fatalf("Failed to build dynamic client: %s", err.Error())
+	}
+
+	ipamcfg, err := ipamRestConfig(k8scfg)
+	if err != nil {
+		glog.Fatalf("Failed to build IPAM kubeconfig: %s", err.Error())
+	}
+
+	blendedclient, err := blended.NewForConfig(ipamcfg)
 	if err != nil {
 		glog.Fatalf("Failed to build Blended client: %s", err.Error())
 	}
 
+	switch command {
+	case exportCommand:
+		if err := runExport(k8sclient, operator.NewAllocator(cfg, blendedclient)); err != nil {
+			glog.Fatalf("Failed to export allocations: %s", err.Error())
+		}
+		return
+	case importCommand:
+		if err := runImport(k8sclient, operator.NewAllocator(cfg, blendedclient)); err != nil {
+			glog.Fatalf("Failed to import allocations: %s", err.Error())
+		}
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	op := operator.New(cfg, k8sclient, blendedclient)
+	op, err := operator.New(cfg, k8sclient, dynamicclient, blendedclient)
+	if err != nil {
+		glog.Fatalf("Failed to create operator: %s", err.Error())
+	}
+
+	if cfg.HealthAddress != "" {
+		go func() {
+			glog.Infof("Serving health endpoints on %s", cfg.HealthAddress)
+			if err := http.ListenAndServe(cfg.HealthAddress, op.Handler()); err != nil {
+				glog.Fatalf("Failed to serve health endpoints: %s", err.Error())
+			}
+		}()
+	}
+
 	if err := op.Run(ctx); err != nil {
 		glog.Fatalf("Error serving operator instance: %s.", err)
 	}
 
 	<-signalChan
+	glog.Infof("Shutdown signal received, exiting...")
 	cancel()
 	op.Stop()
-	glog.Infof("Shutdown signal received, exiting...")
 }
@@ -0,0 +1,297 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adoption
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/inwinstack/ip-assigner/pkg/operator/service"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Adopter binds addresses which were assigned before ip-assigner was installed
+// to their Namespaces and Services, so that no new addresses are allocated for them.
+type Adopter struct {
+	clientset kubernetes.Interface
+	allocator k8sutil.Allocator
+	sink      audit.Sink
+	keys      *constants.Keys
+}
+
+// New creates an adopter, which writes the annotations and labels of the keys.
+func New(clientset kubernetes.Interface, allocator k8sutil.Allocator, sink audit.Sink, keys *constants.Keys) *Adopter {
+	return &Adopter{clientset: clientset, allocator: allocator, sink: sink, keys: keys}
+}
+
+// AdoptInventory binds the addresses of the inventory. An existing IP object of an address
+// is marked as owned, otherwise the specific address is requested from the pool.
+func (a *Adopter) AdoptInventory(inv *Inventory) error {
+	var errs []error
+	for _, e := range inv.Entries {
+		if e.Kind == constants.OwnerKindNamespace {
+			e.Namespace = e.Name
+		}
+		if err := a.adoptEntry(&e); err != nil {
+			errs = append(errs, fmt.Errorf("failed to adopt %s '%s/%s': %s", e.Kind, e.Namespace, e.Name, err.Error()))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func (a *Adopter) adoptEntry(e *Entry) error {
+	owner := k8sutil.Owner{Kind: e.Kind, Namespace: e.Namespace, Name: e.Name}
+	ips, err := a.allocator.List(e.Namespace)
+	if err != nil {
+		return err
+	}
+
+	var adopted []*blendedv1.IP
+	for _, addr := range e.Addresses {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid address '%s'", addr)
+		}
+
+		ip, err := a.bind(ips, owner, e.Pool, addr)
+		if err != nil {
+			return err
+		}
+		adopted = append(adopted, ip)
+		ips.Items = append(ips.Items, *ip)
+	}
+
+	if e.Kind == constants.OwnerKindNamespace {
+		return a.annotateNamespace(e.Name, e.Pool, adopted)
+	}
+	return a.annotateService(e.Namespace, e.Name, e.Pool, adopted[0])
+}
+
+// bind marks the IP object of the address as owned, or requests the address when there is no IP object.
+func (a *Adopter) bind(ips *blendedv1.IPList, owner k8sutil.Owner, pool, addr string) (*blendedv1.IP, error) {
+	for _, ip := range ips.Items {
+		if ip.Spec.PoolName != pool || ip.Status.Address != addr || !ip.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
+		}
+		return a.own(&ip, owner)
+	}
+
+	var name string
+	if owner.Kind == constants.OwnerKindService {
+		name = a.serviceIPName(owner, addr)
+	} else {
+		name = k8sutil.FreeNamespaceIPNames(a.allocator, ips, owner.Namespace, pool, 1)[0]
+	}
+
+	req := &k8sutil.Request{Name: name, Pool: pool, Address: addr, Owner: owner}
+	ip, err := a.allocator.Allocate(req)
+	if err != nil {
+		return nil, err
+	}
+	a.record(owner, ip, "requested address of inventory")
+	return ip, nil
+}
+
+// serviceIPName returns the name the service controller would use for the IP of the Service.
+func (a *Adopter) serviceIPName(owner k8sutil.Owner, addr string) string {
+	svc, err := a.clientset.CoreV1().Services(owner.Namespace).Get(owner.Name, metav1.GetOptions{})
+	if err == nil && len(svc.Spec.ExternalIPs) > 0 {
+		return svc.Spec.ExternalIPs[0]
+	}
+	return addr
+}
+
+// own labels the IP with the owner, it fails when the IP is owned by another object.
+func (a *Adopter) own(ip *blendedv1.IP, owner k8sutil.Owner) (*blendedv1.IP, error) {
+	labels := k8sutil.OwnerLabels(a.keys, owner)
+	if ip.Labels[a.keys.ManagedByLabel] == constants.ManagedByValue {
+		if ip.Labels[a.keys.OwnerKindLabel] == owner.Kind && ip.Labels[a.keys.OwnerNameLabel] == owner.Name {
+			return ip, nil
+		}
+		return nil, fmt.Errorf("address '%s' is already owned by %s '%s'",
+			ip.Status.Address, ip.Labels[a.keys.OwnerKindLabel], ip.Labels[a.keys.OwnerNameLabel])
+	}
+
+	ipCopy := ip.DeepCopy()
+	if ipCopy.Labels == nil {
+		ipCopy.Labels = map[string]string{}
+	}
+	for k, v := range labels {
+		ipCopy.Labels[k] = v
+	}
+
+	updated, err := a.allocator.Update(ipCopy)
+	if err != nil {
+		return nil, err
+	}
+	a.record(owner, updated, "existing IP object")
+	return updated, nil
+}
+
+func (a *Adopter) record(owner k8sutil.Owner, ip *blendedv1.IP, reason string) {
+	glog.Infof("Adopted address '%s' (IP '%s/%s') for %s '%s': %s", ip.Status.Address, ip.Namespace, ip.Name, owner.Kind, owner.Name, reason)
+	audit.Record(a.sink, &audit.Event{
+		Action:    audit.ActionAdopt,
+		OwnerKind: owner.Kind,
+		Namespace: owner.Namespace,
+		Name:      owner.Name,
+		Pool:      ip.Spec.PoolName,
+		IP:        ip.Name,
+		Address:   ip.Status.Address,
+		Reason:    reason,
+	})
+}
+
+// annotateNamespace sets the pool and raises the number of IPs, so that the namespace controller
+// keeps the adopted IPs instead of allocating new ones.
+func (a *Adopter) annotateNamespace(name, pool string, ips []*blendedv1.IP) error {
+	ns, err := a.clientset.CoreV1().Namespaces().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	nsCopy := ns.DeepCopy()
+	if nsCopy.Annotations == nil {
+		nsCopy.Annotations = map[string]string{}
+	}
+
+	if current := nsCopy.Annotations[a.keys.PrivatePoolKey]; current != "" && current != pool {
+		return fmt.Errorf("namespace uses pool '%s' instead of '%s'", current, pool)
+	}
+	nsCopy.Annotations[a.keys.PrivatePoolKey] = pool
+
+	if number, err := strconv.Atoi(nsCopy.Annotations[a.keys.NumberOfIPKey]); err != nil || number < len(ips) {
+		nsCopy.Annotations[a.keys.NumberOfIPKey] = strconv.Itoa(len(ips))
+	}
+
+	addrs := strings.Split(nsCopy.Annotations[a.keys.IPsKey], ",")
+	for _, ip := range ips {
+		if ip.Status.Address != "" && !funk.ContainsString(addrs, ip.Status.Address) {
+			addrs = append(addrs, ip.Status.Address)
+		}
+	}
+	nsCopy.Annotations[a.keys.IPsKey] = strings.Trim(strings.Join(addrs, ","), ",")
+
+	_, err = k8sutil.PatchNamespace(a.clientset, ns, nsCopy)
+	return err
+}
+
+// annotateService publishes the adopted public IP on the Service.
+func (a *Adopter) annotateService(namespace, name, pool string, ip *blendedv1.IP) error {
+	svc, err := a.clientset.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	svcCopy := svc.DeepCopy()
+	if svcCopy.Annotations == nil {
+		svcCopy.Annotations = map[string]string{}
+	}
+
+	if current := svcCopy.Annotations[a.keys.PublicIPKey]; current != "" && current != ip.Status.Address {
+		return fmt.Errorf("service already has public IP '%s'", current)
+	}
+	svcCopy.Annotations[a.keys.PublicPoolKey] = pool
+	svcCopy.Annotations[a.keys.PublicIPRefKey] = ip.Name
+	if ip.Status.Address != "" {
+		svcCopy.Annotations[a.keys.PublicIPKey] = ip.Status.Address
+	}
+
+	_, err = k8sutil.PatchService(a.clientset, svc, svcCopy)
+	return err
+}
+
+// AdoptUnlabelled marks the IP objects without owner labels as owned by the Service or
+// Namespace which references their address. IPs which are not referenced are left alone.
+func (a *Adopter) AdoptUnlabelled() error {
+	ips, err := a.allocator.List("")
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range ips.Items {
+		ip := &ips.Items[i]
+		if ip.Labels[a.keys.ManagedByLabel] == constants.ManagedByValue || !ip.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		owner, err := a.findOwner(ip)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if owner == nil {
+			glog.Warningf("No owner references the unlabelled IP '%s/%s' (%s), skipped adopting it", ip.Namespace, ip.Name, ip.Status.Address)
+			continue
+		}
+
+		if _, err := a.own(ip, *owner); err != nil {
+			errs = append(errs, fmt.Errorf("failed to adopt IP '%s/%s': %s", ip.Namespace, ip.Name, err.Error()))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// findOwner returns the Service or Namespace which references the IP.
+func (a *Adopter) findOwner(ip *blendedv1.IP) (*k8sutil.Owner, error) {
+	svcs, err := a.clientset.CoreV1().Services(ip.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, svc := range svcs.Items {
+		if a.referencesIP(&svc, ip) {
+			return &k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: svc.Namespace, Name: svc.Name}, nil
+		}
+	}
+
+	ns, err := a.clientset.CoreV1().Namespaces().Get(ip.Namespace, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	addrs := strings.Split(ns.Annotations[a.keys.IPsKey], ",")
+	if ip.Status.Address != "" && funk.ContainsString(addrs, ip.Status.Address) {
+		return &k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: ns.Name, Name: ns.Name}, nil
+	}
+	return nil, nil
+}
+
+func (a *Adopter) referencesIP(svc *v1.Service, ip *blendedv1.IP) bool {
+	if svc.Annotations[a.keys.PublicPoolKey] != "" && svc.Annotations[a.keys.PublicPoolKey] != ip.Spec.PoolName {
+		return false
+	}
+	if namespace, name := service.IPRef(a.keys, svc); namespace == ip.Namespace && name == ip.Name {
+		return true
+	}
+	return ip.Status.Address != "" && svc.Annotations[a.keys.PublicIPKey] == ip.Status.Address
+}
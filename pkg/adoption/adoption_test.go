@@ -0,0 +1,148 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adoption
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var pools = []*blendedv1.Pool{
+	{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.20"}},
+	},
+	{
+		ObjectMeta: metav1.ObjectMeta{Name: "internet"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"140.11.22.33-140.11.22.40"}},
+	},
+}
+
+// newUnlabelledIP creates an IP object like the ones of an older ip-assigner or another tool.
+func newUnlabelledIP(t *testing.T, allocator *k8sutil.MemoryAllocator, namespace, name, pool, address string) {
+	ip, err := allocator.Allocate(&k8sutil.Request{Name: name, Pool: pool, Address: address, Owner: k8sutil.Owner{Namespace: namespace}})
+	assert.Nil(t, err)
+	ip.Labels = nil
+	_, err = allocator.Update(ip)
+	assert.Nil(t, err)
+}
+
+func TestAdoptInventory(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "test"},
+		Spec:       corev1.ServiceSpec{ExternalIPs: []string{"172.22.132.20"}},
+	}
+	clientset := fake.NewSimpleClientset(ns, svc)
+	allocator := k8sutil.NewMemoryAllocator(pools...)
+	newUnlabelledIP(t, allocator, "test", "existing", "default", "172.22.132.15")
+
+	inv := &Inventory{Entries: []Entry{
+		{Kind: "Namespace", Name: "test", Pool: "default", Addresses: []string{"172.22.132.15", "172.22.132.12"}},
+		{Kind: "Service", Namespace: "test", Name: "svc", Pool: "internet", Addresses: []string{"140.11.22.35"}},
+	}}
+	adopter := New(clientset, allocator, nil, constants.DefaultKeys)
+	assert.Nil(t, adopter.AdoptInventory(inv))
+
+	nsOwner := k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"}
+	ips, err := allocator.ListByOwner(nsOwner)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 2)
+
+	gns, err := clientset.CoreV1().Namespaces().Get(ns.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "default", gns.Annotations[constants.PrivatePoolKey])
+	assert.Equal(t, "2", gns.Annotations[constants.NumberOfIPKey])
+	assert.Equal(t, "172.22.132.15,172.22.132.12", gns.Annotations[constants.IPsKey])
+
+	gsvc, err := clientset.CoreV1().Services(svc.Namespace).Get(svc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "140.11.22.35", gsvc.Annotations[constants.PublicIPKey])
+	assert.Equal(t, "172.22.132.20", gsvc.Annotations[constants.PublicIPRefKey])
+
+	ip, err := allocator.Get("test", "172.22.132.20")
+	assert.Nil(t, err)
+	assert.Equal(t, "svc", ip.Labels[constants.OwnerNameLabel])
+
+	// Adopting again is a no-op.
+	assert.Nil(t, adopter.AdoptInventory(inv))
+	all, err := allocator.List("")
+	assert.Nil(t, err)
+	assert.Len(t, all.Items, 3)
+}
+
+func TestAdoptUnlabelled(t *testing.T) {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{constants.IPsKey: "172.22.132.10"},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "svc",
+			Namespace:   "test",
+			Annotations: map[string]string{constants.PublicPoolKey: "internet", constants.PublicIPKey: "140.11.22.33"},
+		},
+		Spec: corev1.ServiceSpec{ExternalIPs: []string{"172.22.132.20"}},
+	}
+	clientset := fake.NewSimpleClientset(ns, svc)
+	allocator := k8sutil.NewMemoryAllocator(pools...)
+	newUnlabelledIP(t, allocator, "test", "ns-ip", "default", "172.22.132.10")
+	newUnlabelledIP(t, allocator, "test", "172.22.132.20", "internet", "140.11.22.33")
+	newUnlabelledIP(t, allocator, "test", "unknown", "default", "172.22.132.11")
+
+	assert.Nil(t, New(clientset, allocator, nil, constants.DefaultKeys).AdoptUnlabelled())
+
+	ip, err := allocator.Get("test", "ns-ip")
+	assert.Nil(t, err)
+	assert.Equal(t, constants.OwnerKindNamespace, ip.Labels[constants.OwnerKindLabel])
+
+	ip, err = allocator.Get("test", "172.22.132.20")
+	assert.Nil(t, err)
+	assert.Equal(t, constants.OwnerKindService, ip.Labels[constants.OwnerKindLabel])
+	assert.Equal(t, "svc", ip.Labels[constants.OwnerNameLabel])
+
+	ip, err = allocator.Get("test", "unknown")
+	assert.Nil(t, err)
+	assert.Empty(t, ip.Labels)
+}
+
+func TestAdoptInventoryWithClusterAllocator(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}})
+	allocator := k8sutil.NewClusterAllocator(k8sutil.NewMemoryAllocator(pools...), "east", constants.DefaultKeys)
+
+	inv := &Inventory{Entries: []Entry{
+		{Kind: "Namespace", Name: "test", Pool: "default", Addresses: []string{"172.22.132.15", "172.22.132.12", "172.22.132.13"}},
+	}}
+	assert.Nil(t, New(clientset, allocator, nil, constants.DefaultKeys).AdoptInventory(inv))
+
+	ips, err := allocator.List("test")
+	assert.Nil(t, err)
+	var names []string
+	for _, ip := range ips.Items {
+		names = append(names, ip.Name)
+	}
+	assert.Equal(t, []string{"east-test-default-0", "east-test-default-1", "east-test-default-2"}, names)
+}
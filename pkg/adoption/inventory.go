@@ -0,0 +1,120 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adoption
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Entry represents the addresses which an owner already has.
+type Entry struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name"`
+	Pool      string   `json:"pool"`
+	Addresses []string `json:"addresses"`
+}
+
+// Inventory represents the addresses which were assigned before ip-assigner was installed.
+type Inventory struct {
+	Entries []Entry `json:"entries"`
+}
+
+// LoadInventory reads an inventory from a CSV, YAML or JSON file. A CSV file has the
+// columns kind,namespace,name,pool,address, and one row per address.
+func LoadInventory(path string) (*Inventory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var inv *Inventory
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		inv, err = parseCSV(f)
+	} else {
+		inv = &Inventory{}
+		err = yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(inv)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory '%s': %s", path, err.Error())
+	}
+
+	for _, e := range inv.Entries {
+		if err := e.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+func parseCSV(r io.Reader) (*Inventory, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 5
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Inventory{}
+	index := map[string]int{}
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(record[0], "kind") {
+			continue
+		}
+
+		key := strings.Join(record[:4], "/")
+		if j, ok := index[key]; ok {
+			inv.Entries[j].Addresses = append(inv.Entries[j].Addresses, record[4])
+			continue
+		}
+		index[key] = len(inv.Entries)
+		inv.Entries = append(inv.Entries, Entry{
+			Kind:      record[0],
+			Namespace: record[1],
+			Name:      record[2],
+			Pool:      record[3],
+			Addresses: []string{record[4]},
+		})
+	}
+	return inv, nil
+}
+
+func (e *Entry) validate() error {
+	switch {
+	case e.Kind != constants.OwnerKindNamespace && e.Kind != constants.OwnerKindService:
+		return fmt.Errorf("inventory entry '%s': unknown kind '%s'", e.Name, e.Kind)
+	case e.Name == "" || e.Pool == "" || len(e.Addresses) == 0:
+		return fmt.Errorf("inventory entry '%s/%s': name, pool and addresses are required", e.Kind, e.Name)
+	case e.Kind == constants.OwnerKindService && e.Namespace == "":
+		return fmt.Errorf("inventory entry '%s/%s': namespace is required", e.Kind, e.Name)
+	case e.Kind == constants.OwnerKindService && len(e.Addresses) != 1:
+		return fmt.Errorf("inventory entry '%s/%s': a Service has exactly one address", e.Kind, e.Name)
+	}
+	return nil
+}
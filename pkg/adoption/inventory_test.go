@@ -0,0 +1,72 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adoption
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	expected := &Inventory{Entries: []Entry{
+		{Kind: "Namespace", Name: "test", Pool: "default", Addresses: []string{"172.22.132.10", "172.22.132.11"}},
+		{Kind: "Service", Namespace: "test", Name: "svc", Pool: "internet", Addresses: []string{"140.11.22.33"}},
+	}}
+
+	csv := writeFile(t, dir, "inventory.csv", `kind,namespace,name,pool,address
+# Namespace IPs
+Namespace,,test,default,172.22.132.10
+Namespace,,test,default,172.22.132.11
+Service,test,svc,internet,140.11.22.33
+`)
+	inv, err := LoadInventory(csv)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, inv)
+
+	yaml := writeFile(t, dir, "inventory.yml", `entries:
+- kind: Namespace
+  name: test
+  pool: default
+  addresses: [172.22.132.10, 172.22.132.11]
+- kind: Service
+  namespace: test
+  name: svc
+  pool: internet
+  addresses: [140.11.22.33]
+`)
+	inv, err = LoadInventory(yaml)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, inv)
+
+	invalid := writeFile(t, dir, "invalid.csv", "Service,test,svc,internet,140.11.22.33\nService,test,svc,internet,140.11.22.34\n")
+	_, err = LoadInventory(invalid)
+	assert.NotNil(t, err)
+}
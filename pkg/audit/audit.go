@@ -0,0 +1,86 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// ActionAllocate represents an IP was requested from a pool.
+	ActionAllocate = "Allocate"
+	// ActionAssign represents an address was published on its owner.
+	ActionAssign = "Assign"
+	// ActionRelease represents an IP was returned to its pool.
+	ActionRelease = "Release"
+	// ActionRetain represents an IP was kept after its owner was deleted.
+	ActionRetain = "Retain"
+	// ActionPoolSwitch represents the pool of an owner was changed.
+	ActionPoolSwitch = "PoolSwitch"
+	// ActionAdopt represents an existing address was bound to an owner.
+	ActionAdopt = "Adopt"
+)
+
+// Event represents an allocation decision
+type Event struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	OwnerKind string    `json:"ownerKind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Pool      string    `json:"pool,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Address   string    `json:"address,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Sink receives allocation events
+type Sink interface {
+	Record(e *Event) error
+}
+
+type multiSink []Sink
+
+// Multi creates a sink which records events to all sinks
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+func (m multiSink) Record(e *Event) error {
+	var lastErr error
+	for _, sink := range m {
+		if err := sink.Record(e); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Record records an event to the sink, errors are logged since an allocation never fails because of auditing.
+func Record(sink Sink, e *Event) {
+	if sink == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if err := sink.Record(e); err != nil {
+		glog.Errorf("Failed to record %s event of %s '%s/%s': %s", e.Action, e.OwnerKind, e.Namespace, e.Name, err.Error())
+	}
+}
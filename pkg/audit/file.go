@@ -0,0 +1,84 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File writes events as JSON lines, the file is rotated when it reaches the max size.
+// The rotated files are named <path>.1 (newest) to <path>.<maxBackups> (oldest).
+type File struct {
+	sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+}
+
+// NewFile creates a file sink
+func NewFile(path string, maxBytes int64, maxBackups int) *File {
+	return &File{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+}
+
+// Record appends the event to the file
+func (f *File) Record(e *Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.Lock()
+	defer f.Unlock()
+
+	if f.maxBytes > 0 {
+		if info, err := os.Stat(f.path); err == nil && info.Size()+int64(len(line)) > f.maxBytes {
+			if err := f.rotate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(line)
+	return err
+}
+
+func (f *File) rotate() error {
+	if f.maxBackups <= 0 {
+		return os.Remove(f.path)
+	}
+
+	for i := f.maxBackups - 1; i > 0; i-- {
+		src := fmt.Sprintf("%s.%d", f.path, i)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.path, fmt.Sprintf("%s.1", f.path))
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Event
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink := NewFile(path, 0, 0)
+	Record(sink, &Event{Action: ActionAllocate, OwnerKind: "Namespace", Name: "test", Pool: "default"})
+	Record(sink, &Event{Action: ActionAssign, OwnerKind: "Namespace", Name: "test", Address: "172.22.132.10"})
+
+	events := readEvents(t, path)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, ActionAssign, events[1].Action)
+	assert.Equal(t, "172.22.132.10", events[1].Address)
+	assert.False(t, events[1].Time.IsZero())
+}
+
+func TestFileRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink := NewFile(path, 1, 2)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, sink.Record(&Event{Action: ActionRelease, OwnerKind: "Service", Name: name}))
+	}
+
+	assert.Equal(t, "d", readEvents(t, path)[0].Name)
+	assert.Equal(t, "c", readEvents(t, path+".1")[0].Name)
+	assert.Equal(t, "b", readEvents(t, path+".2")[0].Name)
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+type errSink struct{}
+
+func (errSink) Record(e *Event) error {
+	return os.ErrInvalid
+}
+
+func TestMulti(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	sink := Multi(errSink{}, NewFile(path, 0, 0))
+	assert.NotNil(t, sink.Record(&Event{Action: ActionRetain, OwnerKind: "Service", Name: "test"}))
+	assert.Equal(t, 1, len(readEvents(t, path)))
+}
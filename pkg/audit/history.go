@@ -0,0 +1,74 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// HistoryResource is the resource of the cluster-scoped AllocationHistory
+var HistoryResource = schema.GroupVersionResource{Group: "inwinstack.com", Version: "v1", Resource: "allocationhistories"}
+
+// History records each event as an AllocationHistory object
+type History struct {
+	dynamicset dynamic.Interface
+	keys       *constants.Keys
+}
+
+// NewHistory creates an AllocationHistory sink, which labels the objects with the keys.
+func NewHistory(dynamicset dynamic.Interface, keys *constants.Keys) *History {
+	return &History{dynamicset: dynamicset, keys: keys}
+}
+
+// Record creates an AllocationHistory object for the event
+func (h *History) Record(e *Event) error {
+	spec := map[string]interface{}{
+		"time":      e.Time.UTC().Format(time.RFC3339Nano),
+		"action":    e.Action,
+		"ownerKind": e.OwnerKind,
+		"namespace": e.Namespace,
+		"name":      e.Name,
+		"pool":      e.Pool,
+		"ip":        e.IP,
+		"address":   e.Address,
+		"reason":    e.Reason,
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": HistoryResource.GroupVersion().String(),
+		"kind":       "AllocationHistory",
+		"metadata": map[string]interface{}{
+			"name": fmt.Sprintf("%s-%d", strings.ToLower(e.Action), e.Time.UnixNano()),
+			"labels": map[string]interface{}{
+				h.keys.OwnerKindLabel: e.OwnerKind,
+				h.keys.OwnerNameLabel: e.Name,
+			},
+		},
+		"spec": spec,
+	}}
+
+	_, err := h.dynamicset.Resource(HistoryResource).Create(obj, metav1.CreateOptions{})
+	return err
+}
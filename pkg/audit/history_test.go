@@ -0,0 +1,54 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestHistory(t *testing.T) {
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	sink := NewHistory(dynamicset, constants.DefaultKeys)
+
+	e := &Event{
+		Time:      time.Now(),
+		Action:    ActionAssign,
+		OwnerKind: "Service",
+		Namespace: "test",
+		Name:      "test-svc",
+		Pool:      "internet",
+		Address:   "140.11.22.33",
+	}
+	assert.Nil(t, sink.Record(e))
+
+	name := fmt.Sprintf("assign-%d", e.Time.UnixNano())
+	obj, err := dynamicset.Resource(HistoryResource).Get(name, metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	address, _, err := unstructured.NestedString(obj.Object, "spec", "address")
+	assert.Nil(t, err)
+	assert.Equal(t, e.Address, address)
+}
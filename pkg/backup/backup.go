@@ -0,0 +1,186 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/inwinstack/ip-assigner/pkg/operator/service"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Version is the version of the backup format
+const Version = "ip-assigner/v1"
+
+// Backup represents the allocations of a cluster
+type Backup struct {
+	Version    string       `json:"version"`
+	Time       time.Time    `json:"time"`
+	Namespaces []Allocation `json:"namespaces,omitempty"`
+	Services   []Allocation `json:"services,omitempty"`
+}
+
+// Allocation represents the IPs and ip-assigner annotations of an owner
+type Allocation struct {
+	Namespace   string            `json:"namespace,omitempty"`
+	Name        string            `json:"name"`
+	Pool        string            `json:"pool,omitempty"`
+	IPs         []IP              `json:"ips,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// IP represents an IP object which is owned by the owner
+type IP struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// Write encodes the backup as JSON
+func (b *Backup) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(b)
+}
+
+// Read decodes a backup, and checks its version
+func Read(r io.Reader) (*Backup, error) {
+	b := &Backup{}
+	if err := json.NewDecoder(r).Decode(b); err != nil {
+		return nil, err
+	}
+	if b.Version != Version {
+		return nil, fmt.Errorf("unsupported backup version '%s', expected '%s'", b.Version, Version)
+	}
+	return b, nil
+}
+
+// annotations returns the ip-assigner annotations
+func annotations(keys *constants.Keys, all map[string]string) map[string]string {
+	set := map[string]string{}
+	for k, v := range all {
+		if strings.HasPrefix(k, keys.Prefix) {
+			set[k] = v
+		}
+	}
+	return set
+}
+
+func ips(list *blendedv1.IPList) []IP {
+	var ips []IP
+	for _, ip := range list.Items {
+		if ip.Status.Address == "" || !ip.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
+		}
+		ips = append(ips, IP{Name: ip.Name, Address: ip.Status.Address})
+	}
+	return ips
+}
+
+// namespaceIPs returns the IPs of the Namespace. Besides the IPs which are labelled with the Namespace
+// as owner, it includes the unlabelled IPs of its pools whose address is published in its annotations,
+// e.g. the IPs which were allocated before ip-assigner labelled IPs.
+func namespaceIPs(keys *constants.Keys, ns *v1.Namespace, all *blendedv1.IPList) *blendedv1.IPList {
+	pools := []string{namespacePool(keys, ns), ns.Annotations[keys.LatestPoolKey]}
+	published := strings.Split(ns.Annotations[keys.IPsKey], ",")
+
+	list := &blendedv1.IPList{}
+	for _, ip := range all.Items {
+		kind := ip.Labels[keys.OwnerKindLabel]
+		owned := kind == constants.OwnerKindNamespace && ip.Labels[keys.OwnerNameLabel] == ns.Name
+		member := kind == "" && ip.Spec.PoolName != "" && funk.ContainsString(pools, ip.Spec.PoolName) &&
+			funk.ContainsString(published, ip.Status.Address)
+		if owned || member {
+			list.Items = append(list.Items, ip)
+		}
+	}
+	return list
+}
+
+// namespacePool returns the pool of the Namespace, which is the pool assigned by the controller if the
+// annotation was left to the default.
+func namespacePool(keys *constants.Keys, ns *v1.Namespace) string {
+	if pool := ns.Annotations[keys.PrivatePoolKey]; pool != "" {
+		return pool
+	}
+	return ns.Annotations[keys.AssignedPoolKey]
+}
+
+// Export dumps the allocations of all Namespaces and Services, with the annotations of the keys.
+func Export(clientset kubernetes.Interface, allocator k8sutil.Allocator, keys *constants.Keys) (*Backup, error) {
+	b := &Backup{Version: Version, Time: time.Now().UTC()}
+
+	namespaces, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, ns := range namespaces.Items {
+		all, err := allocator.List(ns.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		a := Allocation{
+			Name:        ns.Name,
+			Pool:        namespacePool(keys, &ns),
+			IPs:         ips(namespaceIPs(keys, &ns, all)),
+			Annotations: annotations(keys, ns.Annotations),
+		}
+		if len(a.IPs) > 0 || len(a.Annotations) > 0 {
+			b.Namespaces = append(b.Namespaces, a)
+		}
+	}
+
+	svcs, err := clientset.CoreV1().Services("").List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, svc := range svcs.Items {
+		if svc.Annotations[keys.PublicIPKey] == "" {
+			continue
+		}
+
+		a := Allocation{
+			Namespace:   svc.Namespace,
+			Name:        svc.Name,
+			Pool:        svc.Annotations[keys.PublicPoolKey],
+			Annotations: annotations(keys, svc.Annotations),
+		}
+
+		// Only the owner of a shared IP restores it, the other Services restore the reference.
+		namespace, name := service.IPRef(keys, &svc)
+		ip, err := allocator.Get(namespace, name)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if err == nil && namespace == svc.Namespace && (ip.Labels[keys.OwnerNameLabel] == "" || ip.Labels[keys.OwnerNameLabel] == svc.Name) {
+			a.IPs = ips(&blendedv1.IPList{Items: []blendedv1.IP{*ip}})
+		}
+		b.Services = append(b.Services, a)
+	}
+	return b, nil
+}
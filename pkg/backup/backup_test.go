@@ -0,0 +1,156 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var pools = []*blendedv1.Pool{
+	{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.20"}},
+	},
+	{
+		ObjectMeta: metav1.ObjectMeta{Name: "internet"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"140.11.22.33-140.11.22.40"}},
+	},
+}
+
+func TestExportAndImport(t *testing.T) {
+	allocator := k8sutil.NewMemoryAllocator(pools...)
+	nsOwner := k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"}
+	svcOwner := k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: "test", Name: "svc"}
+	for _, req := range []*k8sutil.Request{
+		{Name: "ns-ip-1", Pool: "default", Address: "172.22.132.13", Owner: nsOwner},
+		{Name: "ns-ip-2", Pool: "default", Address: "172.22.132.17", Owner: nsOwner},
+		{Name: "172.22.132.100", Pool: "internet", Address: "140.11.22.38", Owner: svcOwner},
+	} {
+		_, err := allocator.Allocate(req)
+		assert.Nil(t, err)
+	}
+
+	// An IP of an older ip-assigner, which has no owner labels.
+	unlabelled, err := allocator.Get("test", "ns-ip-2")
+	assert.Nil(t, err)
+	unlabelled.Labels = nil
+	_, err = allocator.Update(unlabelled)
+	assert.Nil(t, err)
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Annotations: map[string]string{
+				constants.PrivatePoolKey: "default",
+				constants.NumberOfIPKey:  "2",
+				constants.IPsKey:         "172.22.132.13,172.22.132.17",
+				"other.com/key":          "value",
+			},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc",
+			Namespace: "test",
+			Annotations: map[string]string{
+				constants.PublicPoolKey:  "internet",
+				constants.PublicIPKey:    "140.11.22.38",
+				constants.PublicIPRefKey: "172.22.132.100",
+			},
+		},
+		Spec: corev1.ServiceSpec{ExternalIPs: []string{"172.22.132.100"}},
+	}
+
+	b, err := Export(fake.NewSimpleClientset(ns, svc), allocator, constants.DefaultKeys)
+	assert.Nil(t, err)
+	assert.Len(t, b.Namespaces, 1)
+	assert.Len(t, b.Namespaces[0].IPs, 2)
+	assert.Len(t, b.Services, 1)
+	assert.NotContains(t, b.Namespaces[0].Annotations, "other.com/key")
+
+	var buf bytes.Buffer
+	assert.Nil(t, b.Write(&buf))
+	restored, err := Read(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Namespaces, restored.Namespaces)
+
+	// Restore into a fresh cluster, where the Service has not been re-created yet.
+	clientset := fake.NewSimpleClientset()
+	freshAllocator := k8sutil.NewMemoryAllocator(pools...)
+	assert.Nil(t, Import(clientset, freshAllocator, constants.DefaultKeys, restored))
+
+	gns, err := clientset.CoreV1().Namespaces().Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "172.22.132.13,172.22.132.17", gns.Annotations[constants.IPsKey])
+
+	for name, address := range map[string]string{"ns-ip-1": "172.22.132.13", "ns-ip-2": "172.22.132.17"} {
+		ip, err := freshAllocator.Get("test", name)
+		assert.Nil(t, err)
+		assert.Equal(t, address, ip.Status.Address)
+	}
+
+	ip, err := freshAllocator.Get("test", "172.22.132.100")
+	assert.Nil(t, err)
+	assert.Equal(t, "140.11.22.38", ip.Status.Address)
+	assert.Equal(t, "true", ip.Labels[constants.RetainedLabel])
+	assert.Equal(t, "svc", ip.Annotations[constants.RetainedClaimKey])
+	assert.NotEmpty(t, ip.Annotations[constants.RetainUntilKey])
+
+	// Importing again is a no-op.
+	assert.Nil(t, Import(clientset, freshAllocator, constants.DefaultKeys, restored))
+}
+
+func TestReadUnsupportedVersion(t *testing.T) {
+	_, err := Read(bytes.NewBufferString(`{"version": "ip-assigner/v0"}`))
+	assert.NotNil(t, err)
+}
+
+// hintAllocator assigns the first free address, like IPAM which only takes the requested address as a hint.
+type hintAllocator struct {
+	*k8sutil.MemoryAllocator
+}
+
+func (a *hintAllocator) Allocate(req *k8sutil.Request) (*blendedv1.IP, error) {
+	hinted := *req
+	hinted.Address = ""
+	return a.MemoryAllocator.Allocate(&hinted)
+}
+
+func TestImportDifferentAddress(t *testing.T) {
+	b := &Backup{
+		Version: Version,
+		Namespaces: []Allocation{
+			{Name: "test", Pool: "default", IPs: []IP{{Name: "ns-ip", Address: "172.22.132.15"}}},
+		},
+	}
+
+	allocator := &hintAllocator{MemoryAllocator: k8sutil.NewMemoryAllocator(pools...)}
+	assert.NotNil(t, Import(fake.NewSimpleClientset(), allocator, constants.DefaultKeys, b))
+
+	ips, err := allocator.List("test")
+	assert.Nil(t, err)
+	assert.Empty(t, ips.Items)
+}
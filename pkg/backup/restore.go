@@ -0,0 +1,205 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/inwinstack/ip-assigner/pkg/operator/service"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// restoreRetainPeriod is how long the IP of a missing Service, which would be released with the Service,
+// is retained for the Service to be re-created.
+const restoreRetainPeriod = time.Hour * 24
+
+// addressPollPeriod and addressTimeout are the period and the timeout of waiting for IPAM to assign the
+// address of a restored IP.
+var (
+	addressPollPeriod = time.Second
+	addressTimeout    = time.Minute * 2
+)
+
+// Import restores the allocations of a backup. It should run before the operator is
+// started, so that the same addresses are requested before any new allocation.
+//
+// Missing Namespaces are created. The public IPs of missing Services are retained for
+// their claim key, so that they are re-attached when the Services are re-created.
+func Import(clientset kubernetes.Interface, allocator k8sutil.Allocator, keys *constants.Keys, b *Backup) error {
+	var errs []error
+	for _, a := range b.Namespaces {
+		if err := importNamespace(clientset, allocator, keys, &a); err != nil {
+			errs = append(errs, fmt.Errorf("failed to import Namespace '%s': %s", a.Name, err.Error()))
+		}
+	}
+
+	for _, a := range b.Services {
+		if err := importService(clientset, allocator, keys, &a); err != nil {
+			errs = append(errs, fmt.Errorf("failed to import Service '%s/%s': %s", a.Namespace, a.Name, err.Error()))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func importNamespace(clientset kubernetes.Interface, allocator k8sutil.Allocator, keys *constants.Keys, a *Allocation) error {
+	ns, err := clientset.CoreV1().Namespaces().Get(a.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		ns, err = clientset.CoreV1().Namespaces().Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: a.Name}})
+		if err != nil {
+			return err
+		}
+	}
+
+	owner := k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: a.Name, Name: a.Name}
+	for _, ip := range a.IPs {
+		if _, err := restoreIP(allocator, keys, owner, a.Pool, ip, nil, 0); err != nil {
+			return err
+		}
+	}
+
+	nsCopy := ns.DeepCopy()
+	nsCopy.Annotations = mergeAnnotations(nsCopy.Annotations, a.Annotations)
+	_, err = k8sutil.PatchNamespace(clientset, ns, nsCopy)
+	return err
+}
+
+func importService(clientset kubernetes.Interface, allocator k8sutil.Allocator, keys *constants.Keys, a *Allocation) error {
+	svc, err := clientset.CoreV1().Services(a.Namespace).Get(a.Name, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	owner := k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: a.Namespace, Name: a.Name}
+	if errors.IsNotFound(err) {
+		claim := a.Annotations[keys.ClaimKey]
+		if claim == "" {
+			claim = a.Name
+		}
+
+		// The IP is retained like the one of a deleted Service, an IP which would have been released
+		// with the Service is kept for the restore period.
+		period, retained := service.RetainPeriod(keys, a.Annotations)
+		if !retained {
+			period = restoreRetainPeriod
+		}
+		for _, ip := range a.IPs {
+			if _, err := restoreIP(allocator, keys, owner, a.Pool, ip, &claim, period); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, ip := range a.IPs {
+		if _, err := restoreIP(allocator, keys, owner, a.Pool, ip, nil, 0); err != nil {
+			return err
+		}
+	}
+
+	svcCopy := svc.DeepCopy()
+	svcCopy.Annotations = mergeAnnotations(svcCopy.Annotations, a.Annotations)
+	_, err = k8sutil.PatchService(clientset, svc, svcCopy)
+	return err
+}
+
+// restoreIP requests the same address of the pool, and verifies that the IP got it. The IP is retained
+// for the claim if it is not nil, and released after the period if it is positive.
+func restoreIP(allocator k8sutil.Allocator, keys *constants.Keys, owner k8sutil.Owner, pool string, backup IP, claim *string, period time.Duration) (*blendedv1.IP, error) {
+	ip, err := allocator.Get(owner.Namespace, backup.Name)
+	if err == nil {
+		if ip, err = waitForAddress(allocator, ip); err != nil {
+			return nil, err
+		}
+		if ip.Status.Address != backup.Address {
+			return nil, fmt.Errorf("IP '%s' already exists with address '%s'", backup.Name, ip.Status.Address)
+		}
+		return ip, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// The address is only a hint for IPAM, so the assigned one is checked.
+	req := &k8sutil.Request{Name: backup.Name, Pool: pool, Address: backup.Address, Owner: owner}
+	ip, err = allocator.Allocate(req)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err = waitForAddress(allocator, ip); err != nil {
+		return nil, err
+	}
+	if ip.Status.Address != backup.Address {
+		if err := allocator.Release(ip); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("IP '%s' got address '%s' instead of '%s', it has been released", backup.Name, ip.Status.Address, backup.Address)
+	}
+
+	if claim != nil {
+		ipCopy := ip.DeepCopy()
+		service.MarkRetained(keys, ipCopy, *claim, period)
+		if ip, err = allocator.Update(ipCopy); err != nil {
+			return nil, err
+		}
+	}
+
+	glog.Infof("Restored IP '%s/%s' with address '%s' for %s '%s'", owner.Namespace, backup.Name, ip.Status.Address, owner.Kind, owner.Name)
+	return ip, nil
+}
+
+// waitForAddress waits until IPAM assigned the address of the IP.
+func waitForAddress(allocator k8sutil.Allocator, ip *blendedv1.IP) (*blendedv1.IP, error) {
+	err := wait.PollImmediate(addressPollPeriod, addressTimeout, func() (bool, error) {
+		if ip.Status.Address != "" {
+			return true, nil
+		}
+		if ip.Status.Phase == blendedv1.IPFailed {
+			return false, fmt.Errorf("IP '%s' failed to get an address", ip.Name)
+		}
+
+		var err error
+		ip, err = allocator.Get(ip.Namespace, ip.Name)
+		return false, err
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, fmt.Errorf("IP '%s' got no address after %s", ip.Name, addressTimeout)
+	}
+	return ip, err
+}
+
+func mergeAnnotations(current, backup map[string]string) map[string]string {
+	if current == nil {
+		current = map[string]string{}
+	}
+	for k, v := range backup {
+		current[k] = v
+	}
+	return current
+}
@@ -0,0 +1,164 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The names of the controllers
+const (
+	NamespaceController = "namespace"
+	ServiceController   = "service"
+	IPClaimController   = "ipclaim"
+	NATController       = "nat"
+	GCController        = "gc"
+)
+
+// Controllers is the list of all controllers
+var Controllers = []string{NamespaceController, ServiceController, IPClaimController, NATController, GCController}
+
+// ControllerConfig contains the workers and the rate limiter of a controller. Failed keys
+// are retried with an exponential backoff from BaseDelay to MaxDelay, and all keys are
+// limited by QPS and Burst.
+type ControllerConfig struct {
+	Workers   int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	QPS       float64
+	Burst     int
+}
+
+// The rate limiter defaults of client-go.
+const (
+	defaultBaseDelay = 5 * time.Millisecond
+	defaultMaxDelay  = 1000 * time.Second
+	defaultQPS       = 10
+	defaultBurst     = 100
+)
+
+// Enabled reports whether the controller is selected, all controllers are selected by default.
+func (c *Config) Enabled(name string) bool {
+	if len(c.EnabledControllers) == 0 {
+		return true
+	}
+	for _, n := range c.EnabledControllers {
+		if n == name || n == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Controller returns the config of the controller, the unset values are defaulted.
+func (c *Config) Controller(name string) ControllerConfig {
+	cc := ControllerConfig{}
+	if v, ok := c.ControllerConfigs[name]; ok {
+		cc = *v
+	}
+	if cc.Workers <= 0 {
+		cc.Workers = c.Threads
+	}
+	if cc.BaseDelay <= 0 {
+		cc.BaseDelay = defaultBaseDelay
+	}
+	if cc.MaxDelay <= 0 {
+		cc.MaxDelay = defaultMaxDelay
+	}
+	if cc.QPS <= 0 {
+		cc.QPS = defaultQPS
+	}
+	if cc.Burst <= 0 {
+		cc.Burst = defaultBurst
+	}
+	return cc
+}
+
+// ParseControllers validates the selected controllers, and parses the worker counts
+// (<name>=<workers>) and rate limits (<name>=<qps>:<burst>:<base-delay>:<max-delay>) of the controllers.
+func (c *Config) ParseControllers(workers, rateLimits []string) error {
+	for _, name := range c.EnabledControllers {
+		if name != "*" && !isController(name) {
+			return fmt.Errorf("unknown controller %q", name)
+		}
+	}
+
+	if c.ControllerConfigs == nil {
+		c.ControllerConfigs = map[string]*ControllerConfig{}
+	}
+
+	for _, value := range workers {
+		cc, v, err := c.controllerValue(value)
+		if err != nil {
+			return err
+		}
+		if cc.Workers, err = strconv.Atoi(v); err != nil || cc.Workers <= 0 {
+			return fmt.Errorf("invalid workers %q", value)
+		}
+	}
+
+	for _, value := range rateLimits {
+		cc, v, err := c.controllerValue(value)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) != 4 {
+			return fmt.Errorf("invalid rate limit %q, expected <name>=<qps>:<burst>:<base-delay>:<max-delay>", value)
+		}
+		if cc.QPS, err = strconv.ParseFloat(parts[0], 64); err != nil {
+			return fmt.Errorf("invalid QPS of %q: %s", value, err.Error())
+		}
+		if cc.Burst, err = strconv.Atoi(parts[1]); err != nil {
+			return fmt.Errorf("invalid burst of %q: %s", value, err.Error())
+		}
+		if cc.BaseDelay, err = time.ParseDuration(parts[2]); err != nil {
+			return fmt.Errorf("invalid base delay of %q: %s", value, err.Error())
+		}
+		if cc.MaxDelay, err = time.ParseDuration(parts[3]); err != nil {
+			return fmt.Errorf("invalid max delay of %q: %s", value, err.Error())
+		}
+	}
+	return nil
+}
+
+// controllerValue splits a <name>=<value> flag, and returns the config of the controller.
+func (c *Config) controllerValue(value string) (*ControllerConfig, string, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || !isController(parts[0]) {
+		return nil, "", fmt.Errorf("invalid controller setting %q", value)
+	}
+
+	cc, ok := c.ControllerConfigs[parts[0]]
+	if !ok {
+		cc = &ControllerConfig{}
+		c.ControllerConfigs[parts[0]] = cc
+	}
+	return cc, parts[1], nil
+}
+
+func isController(name string) bool {
+	for _, n := range Controllers {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControllerEnabled(t *testing.T) {
+	cfg := &Config{}
+	assert.True(t, cfg.Enabled(NamespaceController))
+
+	cfg.EnabledControllers = []string{ServiceController}
+	assert.True(t, cfg.Enabled(ServiceController))
+	assert.False(t, cfg.Enabled(NamespaceController))
+
+	cfg.EnabledControllers = []string{"*"}
+	assert.True(t, cfg.Enabled(GCController))
+}
+
+func TestParseControllers(t *testing.T) {
+	cfg := &Config{Threads: 2, EnabledControllers: []string{NamespaceController, ServiceController}}
+	err := cfg.ParseControllers(
+		[]string{"namespace=1", "service=8"},
+		[]string{"service=50:200:10ms:5m"},
+	)
+	assert.Nil(t, err)
+
+	ns := cfg.Controller(NamespaceController)
+	assert.Equal(t, 1, ns.Workers)
+	assert.Equal(t, defaultBaseDelay, ns.BaseDelay)
+	assert.Equal(t, defaultMaxDelay, ns.MaxDelay)
+	assert.Equal(t, float64(defaultQPS), ns.QPS)
+	assert.Equal(t, defaultBurst, ns.Burst)
+
+	svc := cfg.Controller(ServiceController)
+	assert.Equal(t, ControllerConfig{Workers: 8, QPS: 50, Burst: 200, BaseDelay: 10 * time.Millisecond, MaxDelay: 5 * time.Minute}, svc)
+
+	assert.Equal(t, 2, cfg.Controller(IPClaimController).Workers)
+}
+
+func TestParseControllersInvalid(t *testing.T) {
+	tests := []struct {
+		controllers []string
+		workers     []string
+		rateLimits  []string
+	}{
+		{controllers: []string{"unknown"}},
+		{workers: []string{"service"}},
+		{workers: []string{"service=0"}},
+		{workers: []string{"unknown=1"}},
+		{rateLimits: []string{"service=10:100"}},
+		{rateLimits: []string{"service=10:100:5ms:forever"}},
+	}
+
+	for _, test := range tests {
+		cfg := &Config{EnabledControllers: test.controllers}
+		assert.NotNil(t, cfg.ParseControllers(test.workers, test.rateLimits), "%+v", test)
+	}
+}
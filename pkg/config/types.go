@@ -16,10 +16,102 @@ limitations under the License.
 
 package config
 
+import (
+	"time"
+
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+)
+
 // Config contains the operator config
 type Config struct {
 	Threads     int
 	SyncSec     int
+	MaxRetries  int
 	PrivatePool string
 	PublicPool  string
+
+	AssignerClass      string
+	DefaultClass       bool
+	AnnotationPrefix   string
+	EnabledControllers []string
+	ControllerConfigs  map[string]*ControllerConfig
+	WatchNamespaces    []string
+
+	MigrationDrainPeriod time.Duration
+
+	StandaloneIPAM bool
+	ClusterID      string
+	EnableIPClaims bool
+
+	NATNamespace     string
+	NATConfigMap     string
+	FirewallProvider string
+	FirewallTarget   string
+
+	AuditFile           string
+	AuditFileMaxSizeMB  int
+	AuditFileMaxBackups int
+	AuditHistory        bool
+
+	NotifyEndpoints []string
+	NotifySecret    string
+	NotifyQueueDir  string
+
+	GCInterval    time.Duration
+	GCGracePeriod time.Duration
+	GCDelete      bool
+
+	AdoptInventory  string
+	AdoptUnlabelled bool
+
+	HealthAddress   string
+	WedgeTimeout    time.Duration
+	ShutdownTimeout time.Duration
+
+	keys *constants.Keys
+}
+
+// SetAnnotationPrefix sets the prefix of the annotation and label keys, and derives the keys for it.
+func (c *Config) SetAnnotationPrefix(prefix string) {
+	c.AnnotationPrefix = prefix
+	c.keys = constants.NewKeys(prefix)
+}
+
+// Keys returns the annotation and label keys, which have the default prefix unless another one was set.
+func (c *Config) Keys() *constants.Keys {
+	if c.keys == nil {
+		return constants.DefaultKeys
+	}
+	return c.keys
+}
+
+// Namespaced reports whether the operator only watches some namespaces
+func (c *Config) Namespaced() bool {
+	return len(c.WatchNamespaces) > 0
+}
+
+// Namespaces returns the watched namespaces, an empty namespace means all namespaces.
+func (c *Config) Namespaces() []string {
+	if c.Namespaced() {
+		return c.WatchNamespaces
+	}
+	return []string{""}
+}
+
+// ScopedName appends the namespace to the name of a controller which watches one namespace.
+func (c *Config) ScopedName(name string) string {
+	if len(c.WatchNamespaces) == 1 {
+		return name + "/" + c.WatchNamespaces[0]
+	}
+	return name
+}
+
+// Handles reports whether the instance handles an object with the annotations. Objects
+// without a class belong to the instance without a class, or the instance of the default class.
+func (c *Config) Handles(annotations map[string]string) bool {
+	class := annotations[c.Keys().AssignerClassKey]
+	if class == "" {
+		return c.AssignerClass == "" || c.DefaultClass
+	}
+	return class == c.AssignerClass
 }
@@ -0,0 +1,72 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaces(t *testing.T) {
+	cfg := &Config{}
+	assert.False(t, cfg.Namespaced())
+	assert.Equal(t, []string{""}, cfg.Namespaces())
+	assert.Equal(t, "Services", cfg.ScopedName("Services"))
+
+	cfg.WatchNamespaces = []string{"tenant-a"}
+	assert.True(t, cfg.Namespaced())
+	assert.Equal(t, []string{"tenant-a"}, cfg.Namespaces())
+	assert.Equal(t, "Services/tenant-a", cfg.ScopedName("Services"))
+
+	cfg.WatchNamespaces = []string{"tenant-a", "tenant-b"}
+	assert.Equal(t, "Services", cfg.ScopedName("Services"))
+}
+
+func TestHandles(t *testing.T) {
+	none := map[string]string{}
+	zoneA := map[string]string{constants.AssignerClassKey: "zone-a"}
+	zoneB := map[string]string{constants.AssignerClassKey: "zone-b"}
+
+	cfg := &Config{}
+	assert.True(t, cfg.Handles(none))
+	assert.False(t, cfg.Handles(zoneA))
+
+	cfg.AssignerClass = "zone-a"
+	assert.False(t, cfg.Handles(none))
+	assert.True(t, cfg.Handles(zoneA))
+	assert.False(t, cfg.Handles(zoneB))
+
+	cfg.DefaultClass = true
+	assert.True(t, cfg.Handles(none))
+	assert.False(t, cfg.Handles(zoneB))
+}
+
+func TestAnnotationPrefix(t *testing.T) {
+	cfg := &Config{AssignerClass: "zone-a"}
+	assert.Equal(t, constants.DefaultKeys, cfg.Keys())
+
+	// The class is read from the prefixed key only.
+	cfg.SetAnnotationPrefix("zone-a.example.com/")
+	assert.Equal(t, "zone-a.example.com/assigner-class", cfg.Keys().AssignerClassKey)
+	assert.True(t, cfg.Handles(map[string]string{"zone-a.example.com/assigner-class": "zone-a"}))
+	assert.False(t, cfg.Handles(map[string]string{constants.AssignerClassKey: "zone-a"}))
+
+	// The keys of other configs keep the default prefix.
+	assert.Equal(t, constants.AssignerClassKey, (&Config{}).Keys().AssignerClassKey)
+}
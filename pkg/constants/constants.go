@@ -20,21 +20,106 @@ const Finalizer = "kubernetes"
 
 const PolicyPrefix = "k8s"
 
+// DefaultAnnotationPrefix is the default prefix of all annotation and label keys of ip-assigner.
+const DefaultAnnotationPrefix = "inwinstack.com/"
+
+// DefaultNumberOfIP represents the number of IP for a Namespace.
+const DefaultNumberOfIP = 1
+
 const (
-	// DefaultNumberOfIP represents the number of IP for a Namespace.
-	DefaultNumberOfIP = 1
+	// AssignerClassKey is the key of annotation for the class of the ip-assigner instance which handles the object.
+	AssignerClassKey = DefaultAnnotationPrefix + "assigner-class"
 	// IPsKey is the key of annotation for displaying allocated IPs.
-	IPsKey = "inwinstack.com/allocated-ips"
+	IPsKey = DefaultAnnotationPrefix + "allocated-ips"
 	// LatestIPKey is the key of annotation for displaying the latest of allocated IP.
-	LatestIPKey = "inwinstack.com/allocated-latest-ip"
+	LatestIPKey = DefaultAnnotationPrefix + "allocated-latest-ip"
 	// NumberOfIPKey is the key of annotation for representing the number of IP needs to allocate.
-	NumberOfIPKey = "inwinstack.com/allocate-ip-number"
+	NumberOfIPKey = DefaultAnnotationPrefix + "allocate-ip-number"
 	// PrivatePoolKey is the key of annotation for the private pool for assigning IP.
-	PrivatePoolKey = "inwinstack.com/allocate-pool-name"
+	PrivatePoolKey = DefaultAnnotationPrefix + "allocate-pool-name"
 	// PublicPoolKey is the key of annotation for the public pool for assigning IP.
-	PublicPoolKey = "inwinstack.com/external-pool"
+	PublicPoolKey = DefaultAnnotationPrefix + "external-pool"
 	// PublicIPKey is the key of annotation for displaying allocated public IP.
-	PublicIPKey = "inwinstack.com/allocated-public-ip"
+	PublicIPKey = DefaultAnnotationPrefix + "allocated-public-ip"
 	// LatestPoolKey is the key of annotation for displaying the latest pool name.
-	LatestPoolKey = "inwinstack.com/latest-pool"
+	LatestPoolKey = DefaultAnnotationPrefix + "latest-pool"
+	// AssignedPoolKey is the key of annotation on Namespaces for the pool whose IPs are assigned.
+	AssignedPoolKey = DefaultAnnotationPrefix + "assigned-pool"
+	// ProtectedIPsKey is the key of annotation on Namespaces for the addresses which are never released on scale-down.
+	ProtectedIPsKey = DefaultAnnotationPrefix + "protected-ips"
+	// ReleaseStrategyKey is the key of annotation on Namespaces for choosing which IPs are released on scale-down.
+	ReleaseStrategyKey = DefaultAnnotationPrefix + "release-strategy"
+	// ReleaseIPsKey is the key of annotation on Namespaces for the addresses to release with the Explicit strategy.
+	ReleaseIPsKey = DefaultAnnotationPrefix + "release-ips"
+	// MigrateToPoolKey is the key of annotation on Services for migrating the public IP to another pool.
+	MigrateToPoolKey = DefaultAnnotationPrefix + "migrate-to-pool"
+	// MigrationPublicIPKey is the key of annotation on Services for the public IP of the new pool during a migration.
+	MigrationPublicIPKey = DefaultAnnotationPrefix + "migration-public-ip"
+	// MigrationPublicIPRefKey is the key of annotation on Services for the name of the public IP object of the new pool.
+	MigrationPublicIPRefKey = DefaultAnnotationPrefix + "migration-public-ip-ref"
+	// DrainUntilKey is the key of annotation on Namespaces and Services for the time when the IPs of the old pool are released.
+	DrainUntilKey = DefaultAnnotationPrefix + "drain-until"
+	// PublicIPRefKey is the key of annotation on Services for the name of the public IP object.
+	PublicIPRefKey = DefaultAnnotationPrefix + "public-ip-ref"
+	// RetainPolicyKey is the key of annotation on Services for the retain policy of the public IP.
+	RetainPolicyKey = DefaultAnnotationPrefix + "retain-policy"
+	// ClaimKey is the key of annotation on Services for claiming a retained public IP, defaults to the name.
+	ClaimKey = DefaultAnnotationPrefix + "claim-key"
+	// SharingKey is the key of annotation on Services for sharing one public IP.
+	SharingKey = DefaultAnnotationPrefix + "sharing-key"
+	// AllowCrossNamespaceSharingKey is the key of annotation on Namespaces for allowing Services to share public IPs across namespaces.
+	AllowCrossNamespaceSharingKey = DefaultAnnotationPrefix + "allow-cross-namespace-sharing"
+	// RetainedClaimKey is the key of annotation on IPs for the claim key which the IP is retained for.
+	RetainedClaimKey = DefaultAnnotationPrefix + "retained-claim"
+	// RetainUntilKey is the key of annotation on IPs for the time when a retained IP is released.
+	RetainUntilKey = DefaultAnnotationPrefix + "retain-until"
+	// RequestedAddressKey is the key of annotation on IPs for the specific address which was requested.
+	RequestedAddressKey = DefaultAnnotationPrefix + "requested-address"
+	// ReconcileFailedKey is the key of annotation on Namespaces and Services for the error which parked the object.
+	ReconcileFailedKey = DefaultAnnotationPrefix + "reconcile-failed"
+	// StandaloneAddressKey is the key of annotation on IPs for recording the address assigned in standalone mode.
+	StandaloneAddressKey = DefaultAnnotationPrefix + "standalone-address"
+)
+
+// ManagedByValue is the value of ManagedByLabel.
+const ManagedByValue = "ip-assigner"
+
+const (
+	// ManagedByLabel is the key of label for marking IPs which were allocated by ip-assigner.
+	ManagedByLabel = DefaultAnnotationPrefix + "managed-by"
+	// OwnerKindLabel is the key of label for the kind of object that owns the IP.
+	OwnerKindLabel = DefaultAnnotationPrefix + "owner-kind"
+	// OwnerNameLabel is the key of label for the name of object that owns the IP.
+	OwnerNameLabel = DefaultAnnotationPrefix + "owner-name"
+	// RetainedLabel is the key of label for marking IPs which are retained after their Service was deleted.
+	RetainedLabel = DefaultAnnotationPrefix + "retained"
+	// ClusterIDLabel is the key of label for the cluster that the IP was allocated for.
+	ClusterIDLabel = DefaultAnnotationPrefix + "cluster-id"
+)
+
+const (
+	// OwnerKindNamespace represents an IP which is owned by a Namespace.
+	OwnerKindNamespace = "Namespace"
+	// OwnerKindService represents an IP which is owned by a Service.
+	OwnerKindService = "Service"
+	// OwnerKindIPClaim represents an IP which is owned by an IPClaim.
+	OwnerKindIPClaim = "IPClaim"
+)
+
+const (
+	// ReleaseNewestFirst releases the most recently updated IPs first.
+	ReleaseNewestFirst = "NewestFirst"
+	// ReleaseOldestFirst releases the least recently updated IPs first.
+	ReleaseOldestFirst = "OldestFirst"
+	// ReleaseExplicit releases only the addresses listed by ReleaseIPsKey.
+	ReleaseExplicit = "Explicit"
+)
+
+const (
+	// RetainPolicyDelete releases the public IP when the Service is deleted.
+	RetainPolicyDelete = "Delete"
+	// RetainPolicyRetain keeps the public IP reserved until a Service claims it again.
+	RetainPolicyRetain = "Retain"
+	// RetainPolicyRetainFor keeps the public IP reserved for a duration, e.g. RetainFor=24h.
+	RetainPolicyRetainFor = "RetainFor"
 )
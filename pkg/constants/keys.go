@@ -0,0 +1,102 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constants
+
+import "strings"
+
+// Keys holds the annotation and label keys of ip-assigner with the configured prefix. The fields are named after
+// the constants, which are the keys with the default prefix.
+type Keys struct {
+	// Prefix is the prefix of all keys, e.g. "zone-a.example.com/".
+	Prefix string
+
+	AssignerClassKey              string
+	IPsKey                        string
+	LatestIPKey                   string
+	NumberOfIPKey                 string
+	PrivatePoolKey                string
+	PublicPoolKey                 string
+	PublicIPKey                   string
+	LatestPoolKey                 string
+	AssignedPoolKey               string
+	ProtectedIPsKey               string
+	ReleaseStrategyKey            string
+	ReleaseIPsKey                 string
+	MigrateToPoolKey              string
+	MigrationPublicIPKey          string
+	MigrationPublicIPRefKey       string
+	DrainUntilKey                 string
+	PublicIPRefKey                string
+	RetainPolicyKey               string
+	ClaimKey                      string
+	SharingKey                    string
+	AllowCrossNamespaceSharingKey string
+	RetainedClaimKey              string
+	RetainUntilKey                string
+	RequestedAddressKey           string
+	ReconcileFailedKey            string
+	StandaloneAddressKey          string
+	ManagedByLabel                string
+	OwnerKindLabel                string
+	OwnerNameLabel                string
+	RetainedLabel                 string
+	ClusterIDLabel                string
+}
+
+// DefaultKeys are the keys with the default prefix.
+var DefaultKeys = NewKeys(DefaultAnnotationPrefix)
+
+// NewKeys derives the keys for the prefix from the constants.
+func NewKeys(prefix string) *Keys {
+	key := func(k string) string {
+		return prefix + strings.TrimPrefix(k, DefaultAnnotationPrefix)
+	}
+	return &Keys{
+		Prefix:                        prefix,
+		AssignerClassKey:              key(AssignerClassKey),
+		IPsKey:                        key(IPsKey),
+		LatestIPKey:                   key(LatestIPKey),
+		NumberOfIPKey:                 key(NumberOfIPKey),
+		PrivatePoolKey:                key(PrivatePoolKey),
+		PublicPoolKey:                 key(PublicPoolKey),
+		PublicIPKey:                   key(PublicIPKey),
+		LatestPoolKey:                 key(LatestPoolKey),
+		AssignedPoolKey:               key(AssignedPoolKey),
+		ProtectedIPsKey:               key(ProtectedIPsKey),
+		ReleaseStrategyKey:            key(ReleaseStrategyKey),
+		ReleaseIPsKey:                 key(ReleaseIPsKey),
+		MigrateToPoolKey:              key(MigrateToPoolKey),
+		MigrationPublicIPKey:          key(MigrationPublicIPKey),
+		MigrationPublicIPRefKey:       key(MigrationPublicIPRefKey),
+		DrainUntilKey:                 key(DrainUntilKey),
+		PublicIPRefKey:                key(PublicIPRefKey),
+		RetainPolicyKey:               key(RetainPolicyKey),
+		ClaimKey:                      key(ClaimKey),
+		SharingKey:                    key(SharingKey),
+		AllowCrossNamespaceSharingKey: key(AllowCrossNamespaceSharingKey),
+		RetainedClaimKey:              key(RetainedClaimKey),
+		RetainUntilKey:                key(RetainUntilKey),
+		RequestedAddressKey:           key(RequestedAddressKey),
+		ReconcileFailedKey:            key(ReconcileFailedKey),
+		StandaloneAddressKey:          key(StandaloneAddressKey),
+		ManagedByLabel:                key(ManagedByLabel),
+		OwnerKindLabel:                key(OwnerKindLabel),
+		OwnerNameLabel:                key(OwnerNameLabel),
+		RetainedLabel:                 key(RetainedLabel),
+		ClusterIDLabel:                key(ClusterIDLabel),
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constants
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewKeys(t *testing.T) {
+	keys := NewKeys("zone-a.example.com/")
+	assert.Equal(t, "zone-a.example.com/", keys.Prefix)
+	assert.Equal(t, "zone-a.example.com/allocated-ips", keys.IPsKey)
+	assert.Equal(t, "zone-a.example.com/owner-kind", keys.OwnerKindLabel)
+
+	// Every key is derived, and the default keys equal the constants.
+	v := reflect.ValueOf(*keys)
+	for i := 0; i < v.NumField(); i++ {
+		assert.True(t, strings.HasPrefix(v.Field(i).String(), "zone-a.example.com/"), v.Type().Field(i).Name)
+	}
+	assert.Equal(t, IPsKey, DefaultKeys.IPsKey)
+	assert.Equal(t, ClusterIDLabel, DefaultKeys.ClusterIDLabel)
+}
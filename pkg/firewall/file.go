@@ -0,0 +1,43 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"encoding/json"
+
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+)
+
+// File writes the NAT mappings to a JSON file
+type File struct {
+	path string
+}
+
+// NewFile creates a file-based provider
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Sync replaces the file content with the mappings
+func (f *File) Sync(mappings []Mapping) error {
+	data, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return k8sutil.WriteFileAtomic(f.path, data)
+}
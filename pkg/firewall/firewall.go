@@ -0,0 +1,55 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"fmt"
+)
+
+const (
+	// FileProvider is the name of the file-based provider.
+	FileProvider = "file"
+	// HTTPProvider is the name of the HTTP provider.
+	HTTPProvider = "http"
+)
+
+// Mapping represents a 1:1 NAT relationship between a private and a public address.
+type Mapping struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+	PrivateIP string `json:"privateIP"`
+	PublicIP  string `json:"publicIP"`
+}
+
+// Provider pushes the full set of NAT mappings to a firewall.
+type Provider interface {
+	Sync(mappings []Mapping) error
+}
+
+// NewProvider creates a provider by name, the target is a file path or an URL.
+func NewProvider(name, target string) (Provider, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case FileProvider:
+		return NewFile(target), nil
+	case HTTPProvider:
+		return NewHTTP(target), nil
+	}
+	return nil, fmt.Errorf("unknown firewall provider '%s'", name)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var mappings = []Mapping{
+	{
+		Name:      "k8s-test-svc",
+		Namespace: "test",
+		Service:   "svc",
+		PrivateIP: "172.22.132.10",
+		PublicIP:  "140.11.22.33",
+	},
+}
+
+func TestNewProvider(t *testing.T) {
+	provider, err := NewProvider("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, provider)
+
+	_, err = NewProvider("unknown", "")
+	assert.NotNil(t, err)
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "firewall")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mappings.json")
+	provider, err := NewProvider(FileProvider, path)
+	assert.Nil(t, err)
+	assert.Nil(t, provider.Sync(mappings))
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+
+	var got []Mapping
+	assert.Nil(t, json.Unmarshal(data, &got))
+	assert.Equal(t, mappings, got)
+}
+
+func TestHTTPProvider(t *testing.T) {
+	var got struct {
+		Mappings []Mapping `json:"mappings"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer server.Close()
+
+	provider, err := NewProvider(HTTPProvider, server.URL)
+	assert.Nil(t, err)
+	assert.Nil(t, provider.Sync(mappings))
+	assert.Equal(t, mappings, got.Mappings)
+
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failed.Close()
+	assert.NotNil(t, NewHTTP(failed.URL).Sync(mappings))
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultTimeout = time.Second * 10
+
+// HTTP posts the NAT mappings to an HTTP endpoint
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP creates an HTTP provider
+func NewHTTP(url string) *HTTP {
+	return &HTTP{url: url, client: &http.Client{Timeout: defaultTimeout}}
+}
+
+// Sync posts all mappings to the endpoint
+func (h *HTTP) Sync(mappings []Mapping) error {
+	body, err := json.Marshal(map[string]interface{}{"mappings": mappings})
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("firewall endpoint returned %s", resp.Status)
+	}
+	return nil
+}
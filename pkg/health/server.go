@@ -0,0 +1,152 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check returns an error when a dependency is not ready
+type Check func() error
+
+// Server serves the health, readiness and debug endpoints of the operator
+type Server struct {
+	sync.Mutex
+	trackers     []*Tracker
+	checks       map[string]Check
+	wedgeTimeout time.Duration
+	checkTimeout time.Duration
+}
+
+// Report is the state served on /debug/state
+type Report struct {
+	// LeaderElection is always false, every replica runs its controllers.
+	LeaderElection bool     `json:"leaderElection"`
+	Controllers    []*State `json:"controllers"`
+}
+
+// NewServer creates a server, a worker is wedged when it reconciles a key for longer than the wedge timeout,
+// and a readiness check fails when it does not return within the check timeout.
+func NewServer(wedgeTimeout, checkTimeout time.Duration) *Server {
+	return &Server{checks: map[string]Check{}, wedgeTimeout: wedgeTimeout, checkTimeout: checkTimeout}
+}
+
+// AddTracker adds the tracker of a controller
+func (s *Server) AddTracker(t *Tracker) {
+	s.Lock()
+	defer s.Unlock()
+	s.trackers = append(s.trackers, t)
+}
+
+// AddCheck adds a readiness check
+func (s *Server) AddCheck(name string, check Check) {
+	s.Lock()
+	defer s.Unlock()
+	s.checks[name] = check
+}
+
+// Handler returns the handler of the endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/debug/state", s.state)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	var failures []string
+	for _, t := range s.trackers {
+		if keys := t.Wedged(s.wedgeTimeout); len(keys) > 0 {
+			failures = append(failures, fmt.Sprintf("%s: workers wedged on %s", t.Name(), strings.Join(keys, ",")))
+		}
+	}
+	respond(w, failures)
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	// The checks call remote APIs, so they run without holding the lock.
+	s.Lock()
+	var failures []string
+	for _, t := range s.trackers {
+		if !t.Synced() {
+			failures = append(failures, fmt.Sprintf("%s: informer caches not synced", t.Name()))
+		}
+	}
+	checks := make(map[string]Check, len(s.checks))
+	for name, check := range s.checks {
+		checks[name] = check
+	}
+	s.Unlock()
+
+	for name, check := range checks {
+		if err := s.runCheck(check); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	}
+	respond(w, failures)
+}
+
+// runCheck runs the check, it fails when the check does not return within the check timeout.
+func (s *Server) runCheck(check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(s.checkTimeout):
+		return fmt.Errorf("timed out after %s", s.checkTimeout)
+	}
+}
+
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	report := &Report{Controllers: make([]*State, 0, len(s.trackers))}
+	for _, t := range s.trackers {
+		report.Controllers = append(report.Controllers, t.State())
+	}
+	s.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
+
+func respond(w http.ResponseWriter, failures []string) {
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(failures, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
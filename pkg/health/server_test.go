@@ -0,0 +1,111 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func get(t *testing.T, s *Server, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestServerHealthz(t *testing.T) {
+	s := NewServer(time.Millisecond, time.Second)
+	tracker := NewTracker("Services", func() int { return 0 }, func() bool { return true })
+	s.AddTracker(tracker)
+	assert.Equal(t, http.StatusOK, get(t, s, "/healthz").Code)
+
+	tracker.Start("default/test")
+	time.Sleep(time.Millisecond * 10)
+	w := get(t, s, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "Services: workers wedged on default/test")
+}
+
+func TestServerReadyz(t *testing.T) {
+	synced := false
+	var checkErr error
+
+	s := NewServer(time.Minute, time.Second)
+	s.AddTracker(NewTracker("Namespaces", func() int { return 0 }, func() bool { return synced }))
+	s.AddCheck("blended", func() error { return checkErr })
+
+	w := get(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "Namespaces: informer caches not synced")
+
+	synced = true
+	checkErr = fmt.Errorf("pools not found")
+	w = get(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "blended: pools not found")
+
+	checkErr = nil
+	assert.Equal(t, http.StatusOK, get(t, s, "/readyz").Code)
+}
+
+func TestServerReadyzTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	s := NewServer(time.Minute, time.Millisecond*10)
+	tracker := NewTracker("Services", func() int { return 0 }, func() bool { return true })
+	s.AddTracker(tracker)
+	s.AddCheck("blended", func() error {
+		<-block
+		return nil
+	})
+
+	w := get(t, s, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "blended: timed out after 10ms")
+
+	// The lock is not held by the blocked check.
+	assert.Equal(t, http.StatusOK, get(t, s, "/healthz").Code)
+}
+
+func TestServerState(t *testing.T) {
+	s := NewServer(time.Minute, time.Second)
+	tracker := NewTracker("Services", func() int { return 2 }, func() bool { return true })
+	s.AddTracker(tracker)
+	tracker.Start("default/a")
+	tracker.Start("default/b")
+	tracker.Finish("default/b", fmt.Errorf("pool not found"))
+
+	w := get(t, s, "/debug/state")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var report Report
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.False(t, report.LeaderElection)
+	assert.Contains(t, w.Body.String(), `"leaderElection": false`)
+	states := report.Controllers
+	assert.Len(t, states, 1)
+	assert.Equal(t, 2, states[0].QueueLen)
+	assert.Contains(t, states[0].InFlight, "default/a")
+	assert.Equal(t, "pool not found", states[0].Results["default/b"][0].Error)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// resultsPerKey is the number of recent results kept for each key.
+	resultsPerKey = 5
+	// maxKeys is the number of keys kept, the least recently reconciled keys are dropped.
+	maxKeys = 1000
+)
+
+// Result represents the result of a reconcile
+type Result struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// ring keeps the latest results of a key
+type ring struct {
+	results []Result
+	next    int
+	updated time.Time
+}
+
+func (r *ring) add(result Result) {
+	if len(r.results) < resultsPerKey {
+		r.results = append(r.results, result)
+	} else {
+		r.results[r.next] = result
+	}
+	r.next = (r.next + 1) % resultsPerKey
+	r.updated = result.Time
+}
+
+// list returns the results from the oldest to the newest
+func (r *ring) list() []Result {
+	if len(r.results) < resultsPerKey {
+		return append([]Result(nil), r.results...)
+	}
+	return append(append([]Result(nil), r.results[r.next:]...), r.results[:r.next]...)
+}
+
+// Tracker records the work of a controller
+type Tracker struct {
+	sync.Mutex
+	name     string
+	queueLen func() int
+	synced   func() bool
+	inFlight map[string]time.Time
+	results  map[string]*ring
+}
+
+// State represents the state of a controller
+type State struct {
+	Name     string               `json:"name"`
+	Synced   bool                 `json:"synced"`
+	QueueLen int                  `json:"queueLength"`
+	InFlight map[string]time.Time `json:"inFlight"`
+	Results  map[string][]Result  `json:"results"`
+}
+
+// NewTracker creates a tracker for the controller, the functions report the length
+// of its queue and whether its informer caches were synced.
+func NewTracker(name string, queueLen func() int, synced func() bool) *Tracker {
+	return &Tracker{
+		name:     name,
+		queueLen: queueLen,
+		synced:   synced,
+		inFlight: map[string]time.Time{},
+		results:  map[string]*ring{},
+	}
+}
+
+// Name returns the name of the controller
+func (t *Tracker) Name() string {
+	return t.name
+}
+
+// Synced reports whether the informer caches of the controller were synced
+func (t *Tracker) Synced() bool {
+	return t.synced()
+}
+
+// Start records that a worker started reconciling the key
+func (t *Tracker) Start(key string) {
+	t.Lock()
+	defer t.Unlock()
+	t.inFlight[key] = time.Now()
+}
+
+// Finish records the result of reconciling the key
+func (t *Tracker) Finish(key string, err error) {
+	t.Lock()
+	defer t.Unlock()
+
+	start, ok := t.inFlight[key]
+	if !ok {
+		return
+	}
+	delete(t.inFlight, key)
+
+	result := Result{Time: time.Now(), Duration: time.Since(start)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	r, ok := t.results[key]
+	if !ok {
+		if len(t.results) >= maxKeys {
+			t.evict()
+		}
+		r = &ring{}
+		t.results[key] = r
+	}
+	r.add(result)
+}
+
+func (t *Tracker) evict() {
+	var oldest string
+	for key, r := range t.results {
+		if oldest == "" || r.updated.Before(t.results[oldest].updated) {
+			oldest = key
+		}
+	}
+	delete(t.results, oldest)
+}
+
+// Wedged returns the keys which have been reconciled for longer than the timeout
+func (t *Tracker) Wedged(timeout time.Duration) []string {
+	t.Lock()
+	defer t.Unlock()
+
+	var keys []string
+	for key, start := range t.inFlight {
+		if time.Since(start) > timeout {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InFlight returns the keys which are being reconciled
+func (t *Tracker) InFlight() []string {
+	t.Lock()
+	defer t.Unlock()
+
+	keys := make([]string, 0, len(t.inFlight))
+	for key := range t.inFlight {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// State returns a snapshot of the controller state
+func (t *Tracker) State() *State {
+	t.Lock()
+	defer t.Unlock()
+
+	state := &State{
+		Name:     t.name,
+		Synced:   t.synced(),
+		QueueLen: t.queueLen(),
+		InFlight: map[string]time.Time{},
+		Results:  map[string][]Result{},
+	}
+	for key, start := range t.inFlight {
+		state.InFlight[key] = start
+	}
+	for key, r := range t.results {
+		state.Results[key] = r.list()
+	}
+	return state
+}
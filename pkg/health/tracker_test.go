@@ -0,0 +1,77 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackerResults(t *testing.T) {
+	tracker := NewTracker("test", func() int { return 3 }, func() bool { return true })
+
+	for i := 0; i < resultsPerKey+2; i++ {
+		tracker.Start("default/test")
+		var err error
+		if i == resultsPerKey+1 {
+			err = fmt.Errorf("failed %d", i)
+		}
+		tracker.Finish("default/test", err)
+	}
+
+	state := tracker.State()
+	assert.Equal(t, "test", state.Name)
+	assert.Equal(t, 3, state.QueueLen)
+	assert.True(t, state.Synced)
+	assert.Empty(t, state.InFlight)
+
+	results := state.Results["default/test"]
+	assert.Len(t, results, resultsPerKey)
+	assert.Equal(t, "failed 6", results[len(results)-1].Error)
+	for i := 1; i < len(results); i++ {
+		assert.False(t, results[i].Time.Before(results[i-1].Time))
+	}
+}
+
+func TestTrackerEvict(t *testing.T) {
+	tracker := NewTracker("test", func() int { return 0 }, func() bool { return true })
+	for i := 0; i <= maxKeys; i++ {
+		key := fmt.Sprintf("default/test-%d", i)
+		tracker.Start(key)
+		tracker.Finish(key, nil)
+	}
+
+	state := tracker.State()
+	assert.Len(t, state.Results, maxKeys)
+	assert.Contains(t, state.Results, fmt.Sprintf("default/test-%d", maxKeys))
+}
+
+func TestTrackerWedged(t *testing.T) {
+	tracker := NewTracker("test", func() int { return 0 }, func() bool { return true })
+	tracker.Start("default/test")
+	assert.Empty(t, tracker.Wedged(time.Hour))
+
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, []string{"default/test"}, tracker.Wedged(time.Millisecond))
+	assert.Contains(t, tracker.State().InFlight, "default/test")
+
+	tracker.Finish("default/test", nil)
+	assert.Empty(t, tracker.Wedged(time.Millisecond))
+}
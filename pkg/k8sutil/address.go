@@ -0,0 +1,148 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+)
+
+// ParseAddressRange parses an address entry of a pool, which is a single address,
+// a range (172.22.132.10-172.22.132.15) or a CIDR (172.22.132.0/24). For IPv4 CIDRs,
+// the network and broadcast addresses are excluded.
+func ParseAddressRange(entry string) (net.IP, net.IP, error) {
+	entry = strings.TrimSpace(entry)
+	switch {
+	case strings.Contains(entry, "-"):
+		parts := strings.SplitN(entry, "-", 2)
+		start, end := net.ParseIP(strings.TrimSpace(parts[0])), net.ParseIP(strings.TrimSpace(parts[1]))
+		if start == nil || end == nil || bytes.Compare(start.To16(), end.To16()) > 0 {
+			return nil, nil, fmt.Errorf("invalid address range '%s'", entry)
+		}
+		return start, end, nil
+	case strings.Contains(entry, "/"):
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		start := ipnet.IP.To16()
+		end := make(net.IP, len(start))
+		mask := ipnet.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12], mask...)
+		}
+		for i := range start {
+			end[i] = start[i] | ^mask[i]
+		}
+
+		if ones, bits := ipnet.Mask.Size(); bits == 32 && ones < 31 {
+			start, end = nextIP(start), prevIP(end)
+		}
+		return start, end, nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, nil, fmt.Errorf("invalid address '%s'", entry)
+	}
+	return ip, ip, nil
+}
+
+// PoolContains reports whether the address belongs to the pool.
+func PoolContains(pool *blendedv1.Pool, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, entry := range pool.Spec.Addresses {
+		start, end, err := ParseAddressRange(entry)
+		if err != nil {
+			continue
+		}
+		if bytes.Compare(ip.To16(), start.To16()) >= 0 && bytes.Compare(ip.To16(), end.To16()) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestAddress returns the address for a request, which is the requested address
+// or the first free address of the pool.
+func RequestAddress(pool *blendedv1.Pool, used map[string]bool, requested string) (string, error) {
+	if requested == "" {
+		return NextFreeAddress(pool, used)
+	}
+
+	ip := net.ParseIP(requested)
+	if ip == nil || !PoolContains(pool, requested) {
+		return "", fmt.Errorf("address '%s' does not belong to pool '%s'", requested, pool.Name)
+	}
+	if used[ip.String()] {
+		return "", fmt.Errorf("address '%s' of pool '%s' is already in use", requested, pool.Name)
+	}
+	return ip.String(), nil
+}
+
+// NextFreeAddress returns the first address of the pool which is not in use.
+func NextFreeAddress(pool *blendedv1.Pool, used map[string]bool) (string, error) {
+	for _, entry := range pool.Spec.Addresses {
+		start, end, err := ParseAddressRange(entry)
+		if err != nil {
+			return "", err
+		}
+
+		for ip := start; bytes.Compare(ip.To16(), end.To16()) <= 0; ip = nextIP(ip) {
+			if !used[ip.String()] {
+				return ip.String(), nil
+			}
+			if ip.Equal(end) {
+				break
+			}
+		}
+	}
+	return "", fmt.Errorf("pool '%s' has no free address", pool.Name)
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, net.IPv6len)
+	copy(next, ip.To16())
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func prevIP(ip net.IP) net.IP {
+	prev := make(net.IP, net.IPv6len)
+	copy(prev, ip.To16())
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
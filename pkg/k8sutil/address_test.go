@@ -0,0 +1,87 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddressRange(t *testing.T) {
+	tests := []struct {
+		entry string
+		start string
+		end   string
+		err   bool
+	}{
+		{entry: "172.22.132.10-172.22.132.15", start: "172.22.132.10", end: "172.22.132.15"},
+		{entry: "172.22.132.0/24", start: "172.22.132.1", end: "172.22.132.254"},
+		{entry: "172.22.132.8/31", start: "172.22.132.8", end: "172.22.132.9"},
+		{entry: "172.22.132.10", start: "172.22.132.10", end: "172.22.132.10"},
+		{entry: "fd00::/126", start: "fd00::", end: "fd00::3"},
+		{entry: "172.22.132.15-172.22.132.10", err: true},
+		{entry: "foo", err: true},
+	}
+
+	for _, test := range tests {
+		start, end, err := ParseAddressRange(test.entry)
+		if test.err {
+			assert.NotNil(t, err, test.entry)
+			continue
+		}
+		assert.Nil(t, err, test.entry)
+		assert.Equal(t, test.start, start.String(), test.entry)
+		assert.Equal(t, test.end, end.String(), test.entry)
+	}
+}
+
+func TestNextFreeAddress(t *testing.T) {
+	pool := newPool("default", "172.22.132.10-172.22.132.11", "172.22.133.0/30")
+
+	address, err := NextFreeAddress(pool, map[string]bool{})
+	assert.Nil(t, err)
+	assert.Equal(t, "172.22.132.10", address)
+
+	address, err = NextFreeAddress(pool, map[string]bool{"172.22.132.10": true, "172.22.132.11": true})
+	assert.Nil(t, err)
+	assert.Equal(t, "172.22.133.1", address)
+
+	_, err = NextFreeAddress(pool, map[string]bool{"172.22.132.10": true, "172.22.132.11": true, "172.22.133.1": true, "172.22.133.2": true})
+	assert.NotNil(t, err)
+
+	assert.True(t, PoolContains(pool, "172.22.133.2"))
+	assert.False(t, PoolContains(pool, "172.22.133.3"))
+}
+
+func TestRequestAddress(t *testing.T) {
+	pool := newPool("default", "172.22.132.10-172.22.132.15")
+
+	address, err := RequestAddress(pool, map[string]bool{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "172.22.132.10", address)
+
+	address, err = RequestAddress(pool, map[string]bool{}, "172.22.132.12")
+	assert.Nil(t, err)
+	assert.Equal(t, "172.22.132.12", address)
+
+	_, err = RequestAddress(pool, map[string]bool{"172.22.132.12": true}, "172.22.132.12")
+	assert.NotNil(t, err)
+
+	_, err = RequestAddress(pool, map[string]bool{}, "172.22.133.12")
+	assert.NotNil(t, err)
+}
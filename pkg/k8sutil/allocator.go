@@ -0,0 +1,175 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"fmt"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Owner represents the object that an IP is allocated for. The IP is
+// always created in the namespace of the owner.
+type Owner struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// Request represents a request for allocating an IP. The Address is optional,
+// and asks for a specific address of the pool.
+type Request struct {
+	Name    string
+	Pool    string
+	Address string
+	Owner   Owner
+	Labels  map[string]string
+}
+
+// Allocator represents an IPAM backend.
+type Allocator interface {
+	// Allocate requests an IP from the pool for the owner.
+	Allocate(req *Request) (*blendedv1.IP, error)
+	// Release returns the IP to its pool.
+	Release(ip *blendedv1.IP) error
+	// Update updates the metadata of the IP.
+	Update(ip *blendedv1.IP) (*blendedv1.IP, error)
+	// Patch patches the label and annotation changes from old to new of the IP.
+	Patch(old, new *blendedv1.IP) (*blendedv1.IP, error)
+	// Get returns the IP by name.
+	Get(namespace, name string) (*blendedv1.IP, error)
+	// List returns all IPs of the namespace, an empty namespace means all namespaces.
+	List(namespace string) (*blendedv1.IPList, error)
+	// ListByOwner returns the IPs which were allocated for the owner.
+	ListByOwner(owner Owner) (*blendedv1.IPList, error)
+	// Pool returns the pool by name.
+	Pool(name string) (*blendedv1.Pool, error)
+	// Name returns the name of the IP object which is created for the requested name.
+	Name(name string) string
+}
+
+// OwnerLabels returns the labels which mark an IP as allocated for the owner.
+func OwnerLabels(keys *constants.Keys, owner Owner) map[string]string {
+	return map[string]string{
+		keys.ManagedByLabel: constants.ManagedByValue,
+		keys.OwnerKindLabel: owner.Kind,
+		keys.OwnerNameLabel: owner.Name,
+	}
+}
+
+// NamespaceIPName returns the name of the IP object for a slot of the Namespace in the pool.
+func NamespaceIPName(namespace, pool string, slot int) string {
+	return fmt.Sprintf("%s-%s-%d", namespace, pool, slot)
+}
+
+// FreeNamespaceIPNames returns the names of the first n slots of the Namespace in the pool, which are not
+// used by the listed IPs. The names are deterministic, so a retried create of the same slot fails with AlreadyExists.
+func FreeNamespaceIPNames(allocator Allocator, ips *blendedv1.IPList, namespace, pool string, n int) []string {
+	used := map[string]bool{}
+	for _, ip := range ips.Items {
+		used[ip.Name] = true
+	}
+
+	names := make([]string, 0, n)
+	for slot := 0; len(names) < n; slot++ {
+		if name := NamespaceIPName(namespace, pool, slot); !used[allocator.Name(name)] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func requestLabels(keys *constants.Keys, req *Request) map[string]string {
+	set := OwnerLabels(keys, req.Owner)
+	for k, v := range req.Labels {
+		set[k] = v
+	}
+	return set
+}
+
+type blendedAllocator struct {
+	blendedset blended.Interface
+	keys       *constants.Keys
+}
+
+// NewBlendedAllocator creates an allocator which is backed by the blended IP and Pool resources.
+func NewBlendedAllocator(blendedset blended.Interface, keys *constants.Keys) Allocator {
+	return &blendedAllocator{blendedset: blendedset, keys: keys}
+}
+
+func (a *blendedAllocator) Allocate(req *Request) (*blendedv1.IP, error) {
+	ip := &blendedv1.IP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      req.Name,
+			Namespace: req.Owner.Namespace,
+			Labels:    requestLabels(a.keys, req),
+		},
+		Spec: blendedv1.IPSpec{
+			PoolName: req.Pool,
+		},
+	}
+
+	// The IPAM operator decides the address, so the specific address is only a hint.
+	if req.Address != "" {
+		ip.Annotations = map[string]string{a.keys.RequestedAddressKey: req.Address}
+	}
+	return a.blendedset.InwinstackV1().IPs(ip.Namespace).Create(ip)
+}
+
+func (a *blendedAllocator) Release(ip *blendedv1.IP) error {
+	return a.blendedset.InwinstackV1().IPs(ip.Namespace).Delete(ip.Name, nil)
+}
+
+func (a *blendedAllocator) Update(ip *blendedv1.IP) (*blendedv1.IP, error) {
+	return a.blendedset.InwinstackV1().IPs(ip.Namespace).Update(ip)
+}
+
+func (a *blendedAllocator) Patch(old, new *blendedv1.IP) (*blendedv1.IP, error) {
+	patch, err := MergeMetadataPatch(old, new)
+	if err != nil || patch == nil {
+		return old, err
+	}
+	return a.blendedset.InwinstackV1().IPs(old.Namespace).Patch(old.Name, types.MergePatchType, patch)
+}
+
+func (a *blendedAllocator) Get(namespace, name string) (*blendedv1.IP, error) {
+	return a.blendedset.InwinstackV1().IPs(namespace).Get(name, metav1.GetOptions{})
+}
+
+func (a *blendedAllocator) List(namespace string) (*blendedv1.IPList, error) {
+	return a.blendedset.InwinstackV1().IPs(namespace).List(metav1.ListOptions{})
+}
+
+func (a *blendedAllocator) ListByOwner(owner Owner) (*blendedv1.IPList, error) {
+	selector := labels.SelectorFromSet(OwnerLabels(a.keys, owner))
+	return a.blendedset.InwinstackV1().IPs(owner.Namespace).List(metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+}
+
+func (a *blendedAllocator) Pool(name string) (*blendedv1.Pool, error) {
+	return a.blendedset.InwinstackV1().Pools().Get(name, metav1.GetOptions{})
+}
+
+func (a *blendedAllocator) Name(name string) string {
+	return name
+}
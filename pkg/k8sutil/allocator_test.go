@@ -0,0 +1,130 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPool(name string, addresses ...string) *blendedv1.Pool {
+	return &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: blendedv1.PoolSpec{
+			Addresses:         addresses,
+			AssignToNamespace: true,
+		},
+	}
+}
+
+func testAllocator(t *testing.T, allocator Allocator) {
+	nsOwner := Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"}
+	svcOwner := Owner{Kind: constants.OwnerKindService, Namespace: "test", Name: "svc"}
+
+	_, err := allocator.Allocate(&Request{Name: "ip1", Pool: "default", Owner: nsOwner})
+	assert.Nil(t, err)
+	ip2, err := allocator.Allocate(&Request{Name: "ip2", Pool: "default", Owner: svcOwner})
+	assert.Nil(t, err)
+
+	pool, err := allocator.Pool("default")
+	assert.Nil(t, err)
+	assert.Equal(t, "default", pool.Name)
+
+	ips, err := allocator.List("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ips.Items))
+
+	ips, err = allocator.ListByOwner(svcOwner)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ips.Items))
+	assert.Equal(t, ip2.Name, ips.Items[0].Name)
+	assert.Equal(t, constants.ManagedByValue, ips.Items[0].Labels[constants.ManagedByLabel])
+
+	ip, err := allocator.Get("test", "ip1")
+	assert.Nil(t, err)
+
+	ip.Labels["test"] = "true"
+	ip, err = allocator.Update(ip)
+	assert.Nil(t, err)
+	assert.Equal(t, "true", ip.Labels["test"])
+
+	// A patch only changes the labels and annotations which differ.
+	ipCopy := ip.DeepCopy()
+	delete(ipCopy.Labels, "test")
+	ipCopy.Annotations = map[string]string{"note": "patched"}
+	ip, err = allocator.Patch(ip, ipCopy)
+	assert.Nil(t, err)
+	assert.Empty(t, ip.Labels["test"])
+	assert.Equal(t, constants.ManagedByValue, ip.Labels[constants.ManagedByLabel])
+	assert.Equal(t, "patched", ip.Annotations["note"])
+	assert.Nil(t, allocator.Release(ip))
+
+	_, err = allocator.Get("test", "ip1")
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestBlendedAllocator(t *testing.T) {
+	blendedset := blendedfake.NewSimpleClientset()
+	_, err := blendedset.InwinstackV1().Pools().Create(newPool("default", "172.22.132.10-172.22.132.15"))
+	assert.Nil(t, err)
+
+	testAllocator(t, NewBlendedAllocator(blendedset, constants.DefaultKeys))
+}
+
+func TestMemoryAllocator(t *testing.T) {
+	allocator := NewMemoryAllocator(newPool("default", "172.22.132.10-172.22.132.11"))
+	testAllocator(t, allocator)
+
+	owner := Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"}
+	ip, err := allocator.Allocate(&Request{Name: "ip3", Pool: "default", Owner: owner})
+	assert.Nil(t, err)
+	assert.Equal(t, blendedv1.IPActive, ip.Status.Phase)
+	assert.Equal(t, "172.22.132.10", ip.Status.Address)
+
+	_, err = allocator.Allocate(&Request{Name: "ip4", Pool: "default", Owner: owner})
+	assert.NotNil(t, err)
+
+	_, err = allocator.Allocate(&Request{Name: "ip3", Pool: "default", Owner: owner})
+	assert.True(t, errors.IsAlreadyExists(err))
+}
+
+func TestFreeNamespaceIPNames(t *testing.T) {
+	ips := &blendedv1.IPList{Items: []blendedv1.IP{
+		{ObjectMeta: metav1.ObjectMeta{Name: "test-default-0"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "test-default-2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "test-other-1"}},
+	}}
+	allocator := NewMemoryAllocator()
+	assert.Equal(t, []string{"test-default-1", "test-default-3"}, FreeNamespaceIPNames(allocator, ips, "test", "default", 2))
+	assert.Equal(t, []string{"test-other-0"}, FreeNamespaceIPNames(allocator, ips, "test", "other", 1))
+	assert.Empty(t, FreeNamespaceIPNames(allocator, ips, "test", "default", 0))
+
+	// The listed names of a cluster have the prefix of the cluster.
+	for i := range ips.Items {
+		ips.Items[i].Name = "east-" + ips.Items[i].Name
+	}
+	cluster := NewClusterAllocator(allocator, "east", constants.DefaultKeys)
+	assert.Equal(t, []string{"test-default-1", "test-default-3"}, FreeNamespaceIPNames(cluster, ips, "test", "default", 2))
+}
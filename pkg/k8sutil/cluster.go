@@ -0,0 +1,92 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"fmt"
+	"strings"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+)
+
+// clusterAllocator scopes an allocator to one cluster, when many clusters share
+// the Pools and IPs of a central cluster. The IPs are prefixed and labelled with
+// the cluster ID to avoid name collisions, and only the IPs of the cluster are listed.
+// Names which already have the prefix are left unchanged, so the names of listed
+// IPs can be passed back to Get.
+type clusterAllocator struct {
+	Allocator
+	clusterID string
+	keys      *constants.Keys
+}
+
+// NewClusterAllocator creates an allocator which is scoped to the cluster ID.
+func NewClusterAllocator(allocator Allocator, clusterID string, keys *constants.Keys) Allocator {
+	return &clusterAllocator{Allocator: allocator, clusterID: clusterID, keys: keys}
+}
+
+// Name returns the name with the prefix of the cluster.
+func (a *clusterAllocator) Name(name string) string {
+	prefix := fmt.Sprintf("%s-", a.clusterID)
+	if strings.HasPrefix(name, prefix) {
+		return name
+	}
+	return prefix + name
+}
+
+func (a *clusterAllocator) Allocate(req *Request) (*blendedv1.IP, error) {
+	scoped := *req
+	scoped.Name = a.Name(req.Name)
+	scoped.Labels = map[string]string{a.keys.ClusterIDLabel: a.clusterID}
+	for k, v := range req.Labels {
+		scoped.Labels[k] = v
+	}
+	return a.Allocator.Allocate(&scoped)
+}
+
+func (a *clusterAllocator) Get(namespace, name string) (*blendedv1.IP, error) {
+	return a.Allocator.Get(namespace, a.Name(name))
+}
+
+func (a *clusterAllocator) List(namespace string) (*blendedv1.IPList, error) {
+	ips, err := a.Allocator.List(namespace)
+	if err != nil {
+		return nil, err
+	}
+	a.filter(ips)
+	return ips, nil
+}
+
+func (a *clusterAllocator) ListByOwner(owner Owner) (*blendedv1.IPList, error) {
+	ips, err := a.Allocator.ListByOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+	a.filter(ips)
+	return ips, nil
+}
+
+func (a *clusterAllocator) filter(ips *blendedv1.IPList) {
+	items := []blendedv1.IP{}
+	for _, ip := range ips.Items {
+		if ip.Labels[a.keys.ClusterIDLabel] == a.clusterID {
+			items = append(items, ip)
+		}
+	}
+	ips.Items = items
+}
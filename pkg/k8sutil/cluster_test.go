@@ -0,0 +1,58 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterAllocator(t *testing.T) {
+	central := NewMemoryAllocator(newPool("default", "172.22.132.10-172.22.132.15"))
+	cluster1 := NewClusterAllocator(central, "cluster1", constants.DefaultKeys)
+	cluster2 := NewClusterAllocator(central, "cluster2", constants.DefaultKeys)
+	testAllocator(t, cluster1)
+
+	owner := Owner{Kind: constants.OwnerKindService, Namespace: "test", Name: "svc"}
+	ip, err := cluster2.Allocate(&Request{Name: "ip2", Pool: "default", Owner: owner})
+	assert.Nil(t, err)
+	assert.Equal(t, "cluster2-ip2", ip.Name)
+	assert.Equal(t, "cluster2", ip.Labels[constants.ClusterIDLabel])
+
+	ips, err := central.List("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ips.Items))
+
+	ips, err = cluster1.ListByOwner(owner)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ips.Items))
+	assert.Equal(t, "cluster1-ip2", ips.Items[0].Name)
+
+	ip, err = cluster2.Get("test", "ip2")
+	assert.Nil(t, err)
+
+	// The name of a listed IP can be passed back.
+	ip, err = cluster2.Get("test", ip.Name)
+	assert.Nil(t, err)
+	assert.Nil(t, cluster2.Release(ip))
+
+	ips, err = cluster2.List("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ips.Items))
+}
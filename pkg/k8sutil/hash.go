@@ -0,0 +1,110 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Hash returns a hash of the JSON encoding of the inputs of a reconcile.
+func Hash(inputs ...interface{}) string {
+	h := sha256.New()
+	encoder := json.NewEncoder(h)
+	for _, in := range inputs {
+		// Maps are encoded with sorted keys, so the hash is stable.
+		encoder.Encode(in)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IPStates returns the sorted states of the IPs which a reconcile depends on.
+func IPStates(ips *blendedv1.IPList) []string {
+	states := make([]string, 0, len(ips.Items))
+	for _, ip := range ips.Items {
+		states = append(states, fmt.Sprintf("%s/%s/%s/%s/%t",
+			ip.Name, ip.Spec.PoolName, ip.Status.Phase, ip.Status.Address, ip.DeletionTimestamp != nil))
+	}
+	sort.Strings(states)
+	return states
+}
+
+// Hashes records the hash of the inputs which were last applied for each key.
+type Hashes struct {
+	sync.Mutex
+	hashes map[string]string
+}
+
+// NewHashes creates an empty record of hashes
+func NewHashes() *Hashes {
+	return &Hashes{hashes: map[string]string{}}
+}
+
+// Applied reports whether the hash was the last applied for the key
+func (h *Hashes) Applied(key, hash string) bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.hashes[key] == hash
+}
+
+// Set records the applied hash of the key
+func (h *Hashes) Set(key, hash string) {
+	h.Lock()
+	defer h.Unlock()
+	h.hashes[key] = hash
+}
+
+// Delete forgets the key
+func (h *Hashes) Delete(key string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.hashes, key)
+}
+
+// OnlyChanged reports whether an update only changed the annotation keys, e.g. when the update was
+// caused by a controller writing its status annotations. Resyncs are never reported.
+func OnlyChanged(old, new metav1.Object, keys ...string) bool {
+	if old.GetResourceVersion() == new.GetResourceVersion() {
+		return false
+	}
+
+	if !reflect.DeepEqual(old.GetLabels(), new.GetLabels()) ||
+		!reflect.DeepEqual(old.GetFinalizers(), new.GetFinalizers()) ||
+		!reflect.DeepEqual(old.GetDeletionTimestamp(), new.GetDeletionTimestamp()) {
+		return false
+	}
+	return reflect.DeepEqual(without(old.GetAnnotations(), keys), without(new.GetAnnotations(), keys))
+}
+
+func without(annotations map[string]string, keys []string) map[string]string {
+	m := map[string]string{}
+	for k, v := range annotations {
+		m[k] = v
+	}
+	for _, k := range keys {
+		delete(m, k)
+	}
+	return m
+}
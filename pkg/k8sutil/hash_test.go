@@ -0,0 +1,70 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHash(t *testing.T) {
+	a := Hash(map[string]string{"a": "1", "b": "2"}, []string{"x"})
+	b := Hash(map[string]string{"b": "2", "a": "1"}, []string{"x"})
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, Hash(map[string]string{"a": "1"}, []string{"x"}))
+
+	ips := &blendedv1.IPList{Items: []blendedv1.IP{
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Status: blendedv1.IPStatus{Phase: blendedv1.IPActive, Address: "172.22.132.11"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Spec: blendedv1.IPSpec{PoolName: "default"}},
+	}}
+	assert.Equal(t, []string{"a/default///false", "b//" + string(blendedv1.IPActive) + "/172.22.132.11/false"}, IPStates(ips))
+
+	hashes := NewHashes()
+	assert.False(t, hashes.Applied("test", a))
+	hashes.Set("test", a)
+	assert.True(t, hashes.Applied("test", b))
+	hashes.Delete("test")
+	assert.False(t, hashes.Applied("test", a))
+}
+
+func TestOnlyChanged(t *testing.T) {
+	old := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:            "test",
+		ResourceVersion: "1",
+		Annotations:     map[string]string{"pool": "default"},
+	}}
+
+	// A resync is never skipped.
+	assert.False(t, OnlyChanged(old, old.DeepCopy(), "ips"))
+
+	status := old.DeepCopy()
+	status.ResourceVersion = "2"
+	status.Annotations["ips"] = "172.22.132.10"
+	assert.True(t, OnlyChanged(old, status, "ips"))
+
+	spec := status.DeepCopy()
+	spec.Annotations["pool"] = "other"
+	assert.False(t, OnlyChanged(old, spec, "ips"))
+
+	finalizers := status.DeepCopy()
+	finalizers.Finalizers = []string{"kubernetes"}
+	assert.False(t, OnlyChanged(old, finalizers, "ips"))
+}
@@ -17,10 +17,16 @@ limitations under the License.
 package k8sutil
 
 import (
+	"sync"
+	"time"
+
 	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
 	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	"github.com/inwinstack/ip-assigner/pkg/config"
 	"github.com/thoas/go-funk"
+	"golang.org/x/time/rate"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
 )
 
 func GetPool(blendedset blended.Interface, meta metav1.ObjectMeta, key string) (*blendedv1.Pool, error) {
@@ -51,3 +57,28 @@ func FilterIPsByPool(ips *blendedv1.IPList, poolName string) {
 	})
 	ips.Items = items.([]blendedv1.IP)
 }
+
+// NewRateLimiter creates the rate limiter of a controller queue, it retries failed keys with
+// an exponential backoff and limits all keys by a token bucket like the default of client-go.
+func NewRateLimiter(cc config.ControllerConfig) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(cc.BaseDelay, cc.MaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(cc.QPS), cc.Burst)},
+	)
+}
+
+// WaitTimeout waits for the wait group, it returns false if the timeout expired first.
+func WaitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
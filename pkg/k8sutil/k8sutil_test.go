@@ -17,7 +17,9 @@ limitations under the License.
 package k8sutil
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
 	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
@@ -90,3 +92,15 @@ func TestFilterIPsByPool(t *testing.T) {
 	FilterIPsByPool(ips, "default")
 	assert.Equal(t, expected, ips)
 }
+
+func TestWaitTimeout(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	assert.False(t, WaitTimeout(&wg, time.Millisecond*10))
+
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		wg.Done()
+	}()
+	assert.True(t, WaitTimeout(&wg, time.Second))
+}
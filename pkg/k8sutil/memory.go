@@ -0,0 +1,221 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"sort"
+	"sync"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	ipResource   = schema.GroupResource{Group: "inwinstack.com", Resource: "ips"}
+	poolResource = schema.GroupResource{Group: "inwinstack.com", Resource: "pools"}
+)
+
+// MemoryAllocator is an in-memory allocator, which assigns addresses immediately.
+// It is intended for tests, and uses the keys with the default prefix.
+type MemoryAllocator struct {
+	sync.Mutex
+	pools map[string]*blendedv1.Pool
+	ips   map[string]*blendedv1.IP
+}
+
+// NewMemoryAllocator creates an in-memory allocator with the pools
+func NewMemoryAllocator(pools ...*blendedv1.Pool) *MemoryAllocator {
+	a := &MemoryAllocator{
+		pools: map[string]*blendedv1.Pool{},
+		ips:   map[string]*blendedv1.IP{},
+	}
+	for _, pool := range pools {
+		a.pools[pool.Name] = pool.DeepCopy()
+	}
+	return a
+}
+
+func memoryKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// Allocate assigns the first free address of the pool
+func (a *MemoryAllocator) Allocate(req *Request) (*blendedv1.IP, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	key := memoryKey(req.Owner.Namespace, req.Name)
+	if _, ok := a.ips[key]; ok {
+		return nil, errors.NewAlreadyExists(ipResource, req.Name)
+	}
+
+	pool, ok := a.pools[req.Pool]
+	if !ok {
+		return nil, errors.NewNotFound(poolResource, req.Pool)
+	}
+
+	used := map[string]bool{}
+	for _, ip := range a.ips {
+		if ip.Spec.PoolName == pool.Name {
+			used[ip.Status.Address] = true
+		}
+	}
+
+	address, err := RequestAddress(pool, used, req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := &blendedv1.IP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      req.Name,
+			Namespace: req.Owner.Namespace,
+			Labels:    requestLabels(constants.DefaultKeys, req),
+		},
+		Spec: blendedv1.IPSpec{
+			PoolName: req.Pool,
+		},
+		Status: blendedv1.IPStatus{
+			Phase:          blendedv1.IPActive,
+			Address:        address,
+			LastUpdateTime: metav1.Now(),
+		},
+	}
+	a.ips[key] = ip
+	return ip.DeepCopy(), nil
+}
+
+// Release removes the IP
+func (a *MemoryAllocator) Release(ip *blendedv1.IP) error {
+	a.Lock()
+	defer a.Unlock()
+
+	key := memoryKey(ip.Namespace, ip.Name)
+	if _, ok := a.ips[key]; !ok {
+		return errors.NewNotFound(ipResource, ip.Name)
+	}
+	delete(a.ips, key)
+	return nil
+}
+
+// Update replaces the IP
+func (a *MemoryAllocator) Update(ip *blendedv1.IP) (*blendedv1.IP, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	key := memoryKey(ip.Namespace, ip.Name)
+	if _, ok := a.ips[key]; !ok {
+		return nil, errors.NewNotFound(ipResource, ip.Name)
+	}
+	a.ips[key] = ip.DeepCopy()
+	return ip.DeepCopy(), nil
+}
+
+// Patch applies the label and annotation changes from old to new to the stored IP
+func (a *MemoryAllocator) Patch(old, new *blendedv1.IP) (*blendedv1.IP, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	key := memoryKey(old.Namespace, old.Name)
+	ip, ok := a.ips[key]
+	if !ok {
+		return nil, errors.NewNotFound(ipResource, old.Name)
+	}
+
+	ip = ip.DeepCopy()
+	ip.Labels = applyMapPatch(ip.Labels, mapPatch(old.Labels, new.Labels))
+	ip.Annotations = applyMapPatch(ip.Annotations, mapPatch(old.Annotations, new.Annotations))
+	a.ips[key] = ip
+	return ip.DeepCopy(), nil
+}
+
+func applyMapPatch(m map[string]string, patch map[string]interface{}) map[string]string {
+	if m == nil {
+		m = map[string]string{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(m, k)
+			continue
+		}
+		m[k] = v.(string)
+	}
+	return m
+}
+
+// Get returns the IP by name
+func (a *MemoryAllocator) Get(namespace, name string) (*blendedv1.IP, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	ip, ok := a.ips[memoryKey(namespace, name)]
+	if !ok {
+		return nil, errors.NewNotFound(ipResource, name)
+	}
+	return ip.DeepCopy(), nil
+}
+
+// List returns all IPs of the namespace
+func (a *MemoryAllocator) List(namespace string) (*blendedv1.IPList, error) {
+	return a.list(namespace, labels.Everything()), nil
+}
+
+// ListByOwner returns the IPs of the owner
+func (a *MemoryAllocator) ListByOwner(owner Owner) (*blendedv1.IPList, error) {
+	return a.list(owner.Namespace, labels.SelectorFromSet(OwnerLabels(constants.DefaultKeys, owner))), nil
+}
+
+// Pool returns the pool by name
+func (a *MemoryAllocator) Pool(name string) (*blendedv1.Pool, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	pool, ok := a.pools[name]
+	if !ok {
+		return nil, errors.NewNotFound(poolResource, name)
+	}
+	return pool.DeepCopy(), nil
+}
+
+// Name returns the name unchanged
+func (a *MemoryAllocator) Name(name string) string {
+	return name
+}
+
+func (a *MemoryAllocator) list(namespace string, selector labels.Selector) *blendedv1.IPList {
+	a.Lock()
+	defer a.Unlock()
+
+	list := &blendedv1.IPList{}
+	for _, ip := range a.ips {
+		if namespace != "" && ip.Namespace != namespace {
+			continue
+		}
+		if selector.Matches(labels.Set(ip.Labels)) {
+			list.Items = append(list.Items, *ip.DeepCopy())
+		}
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return memoryKey(list.Items[i].Namespace, list.Items[i].Name) < memoryKey(list.Items[j].Namespace, list.Items[j].Name)
+	})
+	return list
+}
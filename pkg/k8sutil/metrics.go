@@ -0,0 +1,100 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// firstAllocations publishes the time-to-first-allocation of the controllers in /debug/vars.
+var firstAllocations = expvar.NewMap("first_allocation_seconds")
+
+// latencyBuckets are the upper bounds in seconds of the buckets of a Latency.
+var latencyBuckets = []float64{1, 5, 10, 30, 60, 300, 600}
+
+// Latency is a histogram of durations, which is published by expvar.
+type Latency struct {
+	sync.Mutex
+	count   int64
+	sum     float64
+	max     float64
+	buckets []int64
+}
+
+// NewFirstAllocation creates the time-to-first-allocation histogram of a controller, a controller
+// created again with the same name replaces the histogram.
+func NewFirstAllocation(name string) *Latency {
+	l := &Latency{buckets: make([]int64, len(latencyBuckets)+1)}
+	firstAllocations.Set(name, l)
+	return l
+}
+
+// ObserveSince records the duration since the time, a zero time is ignored.
+func (l *Latency) ObserveSince(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	l.Observe(time.Since(t))
+}
+
+// Observe records the duration
+func (l *Latency) Observe(d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	seconds := d.Seconds()
+	l.count++
+	l.sum += seconds
+	if seconds > l.max {
+		l.max = seconds
+	}
+
+	i := 0
+	for i < len(latencyBuckets) && seconds > latencyBuckets[i] {
+		i++
+	}
+	l.buckets[i]++
+}
+
+// String returns the histogram as JSON, the buckets are cumulative like the ones of Prometheus.
+func (l *Latency) String() string {
+	l.Lock()
+	defer l.Unlock()
+
+	buckets := map[string]int64{}
+	var cumulative int64
+	for i, n := range l.buckets {
+		cumulative += n
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i], 'f', -1, 64)
+		}
+		buckets[le] = cumulative
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"count":   l.count,
+		"sum":     l.sum,
+		"max":     l.max,
+		"buckets": buckets,
+	})
+	return string(b)
+}
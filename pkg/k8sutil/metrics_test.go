@@ -0,0 +1,40 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatency(t *testing.T) {
+	l := NewFirstAllocation("test")
+	l.Observe(time.Millisecond * 500)
+	l.Observe(time.Second * 20)
+	l.Observe(time.Hour)
+	l.ObserveSince(time.Time{})
+
+	assert.JSONEq(t, `{
+		"count": 3,
+		"sum": 3620.5,
+		"max": 3600,
+		"buckets": {"1": 1, "5": 1, "10": 1, "30": 2, "60": 2, "300": 2, "600": 2, "+Inf": 3}
+	}`, l.String())
+	assert.Equal(t, l.String(), firstAllocations.Get("test").String())
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Parker records the keys which failed too many times. A parked key is only retried
+// when its object or the Pool which it references changes.
+type Parker struct {
+	sync.Mutex
+	allocator Allocator
+	keys      map[string]parked
+}
+
+const unknownVersion = "unknown"
+
+// PermanentError is a reconcile error which retrying does not fix, e.g. a missing pool or an invalid
+// annotation. The key of a permanent error is parked at once, other errors are retried with backoff.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Permanent marks the error as permanent, a nil error stays nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether the error was marked as permanent
+func IsPermanent(err error) bool {
+	_, ok := err.(*PermanentError)
+	return ok
+}
+
+type parked struct {
+	resourceVersion string
+	pool            string
+	poolVersion     string
+}
+
+// NewParker creates a parker, which looks up the Pools by the allocator
+func NewParker(allocator Allocator) *Parker {
+	return &Parker{allocator: allocator, keys: map[string]parked{}}
+}
+
+// Park parks the key until the object changes from the resource version, or the pool changes.
+func (p *Parker) Park(key, resourceVersion, pool string) {
+	version, ok := p.poolVersion(pool)
+	if !ok {
+		// The pool is unknown, so any version unparks the key.
+		version = unknownVersion
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	p.keys[key] = parked{resourceVersion: resourceVersion, pool: pool, poolVersion: version}
+}
+
+// Parked reports whether the key is still parked for the resource version of its object,
+// the key is unparked when the object was changed.
+func (p *Parker) Parked(key, resourceVersion string) bool {
+	p.Lock()
+	defer p.Unlock()
+
+	k, ok := p.keys[key]
+	if !ok {
+		return false
+	}
+	if k.resourceVersion != resourceVersion {
+		delete(p.keys, key)
+		return false
+	}
+	return true
+}
+
+// Forget drops the key, e.g. when its object was deleted
+func (p *Parker) Forget(key string) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.keys, key)
+}
+
+// Len returns the number of parked keys
+func (p *Parker) Len() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.keys)
+}
+
+// PoolChanged unparks and returns the keys whose pool was changed, created or deleted.
+func (p *Parker) PoolChanged() []string {
+	p.Lock()
+	pools := map[string]string{}
+	for _, k := range p.keys {
+		pools[k.pool] = ""
+	}
+	p.Unlock()
+
+	for pool := range pools {
+		version, ok := p.poolVersion(pool)
+		if !ok {
+			delete(pools, pool)
+			continue
+		}
+		pools[pool] = version
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	var keys []string
+	for key, k := range p.keys {
+		if version, ok := pools[k.pool]; ok && version != k.poolVersion {
+			delete(p.keys, key)
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// poolVersion returns the resource version of the pool, or an empty version if the pool does not exist.
+func (p *Parker) poolVersion(pool string) (string, bool) {
+	if pool == "" {
+		return "", true
+	}
+
+	obj, err := p.allocator.Pool(pool)
+	if err != nil {
+		return "", errors.IsNotFound(err)
+	}
+	return obj.ResourceVersion, true
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"fmt"
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// poolAllocator serves pools which can be changed by the test.
+type poolAllocator struct {
+	Allocator
+	pools map[string]*blendedv1.Pool
+}
+
+func (a *poolAllocator) Pool(name string) (*blendedv1.Pool, error) {
+	pool, ok := a.pools[name]
+	if !ok {
+		return nil, errors.NewNotFound(poolResource, name)
+	}
+	return pool, nil
+}
+
+func TestParker(t *testing.T) {
+	allocator := &poolAllocator{pools: map[string]*blendedv1.Pool{}}
+	parker := NewParker(allocator)
+
+	parker.Park("test1", "10", "missing")
+	parker.Park("test2", "20", "missing")
+	assert.Equal(t, 2, parker.Len())
+
+	// A resync does not unpark the key, a change does.
+	assert.True(t, parker.Parked("test1", "10"))
+	assert.False(t, parker.Parked("test1", "11"))
+	assert.False(t, parker.Parked("test1", "11"))
+	assert.Equal(t, 1, parker.Len())
+	parker.Forget("test2")
+	assert.Equal(t, 0, parker.Len())
+	parker.Park("test2", "20", "missing")
+
+	assert.Empty(t, parker.PoolChanged())
+
+	// The pool is created.
+	allocator.pools["missing"] = &blendedv1.Pool{ObjectMeta: metav1.ObjectMeta{Name: "missing", ResourceVersion: "1"}}
+	assert.Equal(t, []string{"test2"}, parker.PoolChanged())
+	assert.Equal(t, 0, parker.Len())
+	assert.False(t, parker.Parked("test2", "20"))
+}
+
+func TestPermanent(t *testing.T) {
+	assert.Nil(t, Permanent(nil))
+	err := Permanent(fmt.Errorf("pool not found"))
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, "pool not found", err.Error())
+	assert.False(t, IsPermanent(fmt.Errorf("timeout")))
+}
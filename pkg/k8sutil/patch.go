@@ -0,0 +1,114 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"encoding/json"
+
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MetadataPatch returns a strategic merge patch of the annotation and finalizer changes from old to new,
+// it is nil if nothing changed. Other fields, and the annotations which were not changed, are not touched.
+func MetadataPatch(old, new metav1.Object) ([]byte, error) {
+	metadata := map[string]interface{}{}
+	if annotations := mapPatch(old.GetAnnotations(), new.GetAnnotations()); len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+
+	added := difference(new.GetFinalizers(), old.GetFinalizers())
+	if len(added) > 0 {
+		metadata["finalizers"] = added
+	}
+	removed := difference(old.GetFinalizers(), new.GetFinalizers())
+	if len(removed) > 0 {
+		metadata["$deleteFromPrimitiveList/finalizers"] = removed
+	}
+
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]interface{}{"metadata": metadata})
+}
+
+// MergeMetadataPatch returns a JSON merge patch of the label and annotation changes from old to new, it is nil
+// if nothing changed. Unlike MetadataPatch, it suits custom resources which do not support strategic merge patches.
+func MergeMetadataPatch(old, new metav1.Object) ([]byte, error) {
+	metadata := map[string]interface{}{}
+	if labels := mapPatch(old.GetLabels(), new.GetLabels()); len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if annotations := mapPatch(old.GetAnnotations(), new.GetAnnotations()); len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+
+	if len(metadata) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]interface{}{"metadata": metadata})
+}
+
+// mapPatch returns the changed keys from old to new, the removed keys are nil.
+func mapPatch(old, new map[string]string) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for k, v := range new {
+		if ov, ok := old[k]; !ok || ov != v {
+			patch[k] = v
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+// difference returns the strings of a which are not in b.
+func difference(a, b []string) []string {
+	var diff []string
+	for _, s := range a {
+		if !funk.ContainsString(b, s) {
+			diff = append(diff, s)
+		}
+	}
+	return diff
+}
+
+// PatchNamespace patches the metadata changes from old to new of the Namespace. The patch has no resource
+// version precondition, so it applies to the latest Namespace and cannot conflict with other writers.
+func PatchNamespace(clientset kubernetes.Interface, old, new *v1.Namespace) (*v1.Namespace, error) {
+	patch, err := MetadataPatch(old, new)
+	if err != nil || patch == nil {
+		return old, err
+	}
+	return clientset.CoreV1().Namespaces().Patch(old.Name, types.StrategicMergePatchType, patch)
+}
+
+// PatchService patches the metadata changes from old to new of the Service. The patch has no resource
+// version precondition, so it applies to the latest Service and cannot conflict with other writers.
+func PatchService(clientset kubernetes.Interface, old, new *v1.Service) (*v1.Service, error) {
+	patch, err := MetadataPatch(old, new)
+	if err != nil || patch == nil {
+		return old, err
+	}
+	return clientset.CoreV1().Services(old.Namespace).Patch(old.Name, types.StrategicMergePatchType, patch)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMetadataPatch(t *testing.T) {
+	old := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test",
+		Annotations: map[string]string{"ips": "172.22.132.10", "pool": "default"},
+		Finalizers:  []string{"kubernetes", "ip-assigner"},
+	}}
+
+	patch, err := MetadataPatch(old, old.DeepCopy())
+	assert.Nil(t, err)
+	assert.Nil(t, patch)
+
+	new := old.DeepCopy()
+	new.Annotations["ips"] = "172.22.132.11"
+	new.Annotations["latest-ip"] = "172.22.132.11"
+	delete(new.Annotations, "pool")
+	new.Finalizers = []string{"kubernetes"}
+	patch, err = MetadataPatch(old, new)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"metadata":{
+		"annotations":{"ips":"172.22.132.11","latest-ip":"172.22.132.11","pool":null},
+		"$deleteFromPrimitiveList/finalizers":["ip-assigner"]
+	}}`, string(patch))
+}
+
+func TestPatchNamespace(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test",
+		Annotations: map[string]string{"ips": "172.22.132.10"},
+		Finalizers:  []string{"ip-assigner"},
+	}}
+	clientset := fake.NewSimpleClientset(ns)
+
+	// Another writer changes the Namespace after it was read.
+	other := ns.DeepCopy()
+	other.Annotations["owner"] = "team-a"
+	_, err := clientset.CoreV1().Namespaces().Update(other)
+	assert.Nil(t, err)
+
+	nsCopy := ns.DeepCopy()
+	nsCopy.Annotations["ips"] = "172.22.132.11"
+	nsCopy.Finalizers = nil
+	updated, err := PatchNamespace(clientset, ns, nsCopy)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"ips": "172.22.132.11", "owner": "team-a"}, updated.Annotations)
+	assert.Empty(t, updated.Finalizers)
+
+	// Nothing is written without changes.
+	same, err := PatchNamespace(clientset, updated, updated.DeepCopy())
+	assert.Nil(t, err)
+	assert.Equal(t, updated, same)
+}
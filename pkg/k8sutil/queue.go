@@ -0,0 +1,204 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// PriorityQueue is a rate limited work queue, which hands out the keys added with a high priority
+// before the others. Like the queues of client-go, a key is queued at most once, and is never
+// processed by two workers at the same time.
+type PriorityQueue struct {
+	cond         *sync.Cond
+	high         []interface{}
+	normal       []interface{}
+	dirty        map[interface{}]bool
+	processing   map[interface{}]bool
+	shuttingDown bool
+	rateLimiter  workqueue.RateLimiter
+	// delayed holds the keys added with a delay until they are ready. It is the named delaying queue of
+	// client-go, so a key waits for its earliest deadline only once, the timers stop on ShutDown, and
+	// the adds, depth, latency and retries of the delayed keys are reported by the workqueue metrics.
+	delayed workqueue.DelayingInterface
+}
+
+var _ workqueue.RateLimitingInterface = &PriorityQueue{}
+
+// NewPriorityQueue creates a priority queue with the rate limiter, the name is used by the workqueue metrics.
+func NewPriorityQueue(rateLimiter workqueue.RateLimiter, name string) *PriorityQueue {
+	q := &PriorityQueue{
+		cond:        sync.NewCond(&sync.Mutex{}),
+		dirty:       map[interface{}]bool{},
+		processing:  map[interface{}]bool{},
+		rateLimiter: rateLimiter,
+		delayed:     workqueue.NewNamedDelayingQueue(name),
+	}
+	go q.moveDelayed()
+	return q
+}
+
+// moveDelayed queues the delayed keys with the normal priority once they are ready.
+func (q *PriorityQueue) moveDelayed() {
+	for {
+		item, shutdown := q.delayed.Get()
+		if shutdown {
+			return
+		}
+		q.Add(item)
+		q.delayed.Done(item)
+	}
+}
+
+// Add queues the item with the normal priority
+func (q *PriorityQueue) Add(item interface{}) {
+	q.add(item, false)
+}
+
+// AddPriority queues the item with the high priority, an item which is already queued is moved ahead.
+func (q *PriorityQueue) AddPriority(item interface{}) {
+	q.add(item, true)
+}
+
+func (q *PriorityQueue) add(item interface{}, high bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+
+	if queuedHigh, ok := q.dirty[item]; ok {
+		if high && !queuedHigh {
+			q.dirty[item] = true
+			if !q.processing[item] {
+				q.normal = removeItem(q.normal, item)
+				q.high = append(q.high, item)
+			}
+		}
+		return
+	}
+
+	// The item is queued again once it is done.
+	q.dirty[item] = high
+	if q.processing[item] {
+		return
+	}
+	q.push(item, high)
+}
+
+func (q *PriorityQueue) push(item interface{}, high bool) {
+	if high {
+		q.high = append(q.high, item)
+	} else {
+		q.normal = append(q.normal, item)
+	}
+	q.cond.Signal()
+}
+
+func removeItem(items []interface{}, item interface{}) []interface{} {
+	for i, it := range items {
+		if it == item {
+			return append(items[:i], items[i+1:]...)
+		}
+	}
+	return items
+}
+
+// Len returns the number of queued items
+func (q *PriorityQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return len(q.high) + len(q.normal)
+}
+
+// Get blocks until an item can be processed, the high priority items are returned first.
+func (q *PriorityQueue) Get() (interface{}, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for len(q.high)+len(q.normal) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.high)+len(q.normal) == 0 {
+		return nil, true
+	}
+
+	var item interface{}
+	if len(q.high) > 0 {
+		item, q.high = q.high[0], q.high[1:]
+	} else {
+		item, q.normal = q.normal[0], q.normal[1:]
+	}
+	q.processing[item] = true
+	delete(q.dirty, item)
+	return item, false
+}
+
+// Done marks the item as processed, it is queued again if it was added while it was processed.
+func (q *PriorityQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, item)
+	if high, ok := q.dirty[item]; ok {
+		q.push(item, high)
+	}
+}
+
+// ShutDown makes Get return once the queue is empty, and ignores the items added afterwards
+func (q *PriorityQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	q.delayed.ShutDown()
+}
+
+// ShuttingDown returns whether the queue is shut down
+func (q *PriorityQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
+
+// AddAfter queues the item with the normal priority after the duration
+func (q *PriorityQueue) AddAfter(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	q.delayed.AddAfter(item, duration)
+}
+
+// AddRateLimited queues the item with the normal priority once the rate limiter allows it
+func (q *PriorityQueue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+// Forget resets the backoff of the item
+func (q *PriorityQueue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues returns how many times the item was requeued by AddRateLimited
+func (q *PriorityQueue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func get(t *testing.T, q *PriorityQueue) interface{} {
+	item, shutdown := q.Get()
+	assert.False(t, shutdown)
+	q.Done(item)
+	return item
+}
+
+func TestPriorityQueue(t *testing.T) {
+	q := NewPriorityQueue(workqueue.DefaultControllerRateLimiter(), "test")
+	q.Add("resync-1")
+	q.Add("resync-2")
+	q.AddPriority("new")
+	q.Add("new")
+	assert.Equal(t, 3, q.Len())
+
+	// A queued key is moved ahead once.
+	q.AddPriority("resync-2")
+	q.AddPriority("resync-2")
+	assert.Equal(t, 3, q.Len())
+
+	assert.Equal(t, "new", get(t, q))
+	assert.Equal(t, "resync-2", get(t, q))
+	assert.Equal(t, "resync-1", get(t, q))
+	assert.Equal(t, 0, q.Len())
+
+	// A key added while it is processed is queued again once it is done.
+	q.Add("busy")
+	item, _ := q.Get()
+	q.Add("other")
+	q.AddPriority("busy")
+	assert.Equal(t, 1, q.Len())
+	q.Done(item)
+	assert.Equal(t, "busy", get(t, q))
+	assert.Equal(t, "other", get(t, q))
+
+	// A delayed key waits for its earliest deadline only once.
+	q.AddAfter("later", time.Hour)
+	q.AddAfter("later", time.Millisecond*10)
+	q.AddAfter("later", time.Millisecond*20)
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, "later", get(t, q))
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 0, q.Len())
+
+	q.AddRateLimited("failed")
+	assert.Equal(t, 1, q.NumRequeues("failed"))
+	assert.Equal(t, "failed", get(t, q))
+	q.Forget("failed")
+	assert.Equal(t, 0, q.NumRequeues("failed"))
+
+	q.AddAfter("pending", time.Hour)
+	q.ShutDown()
+	assert.True(t, q.ShuttingDown())
+	q.Add("ignored")
+	_, shutdown := q.Get()
+	assert.True(t, shutdown)
+}
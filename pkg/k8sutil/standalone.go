@@ -0,0 +1,142 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"sync"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// standaloneAllocator assigns addresses by itself instead of waiting for the IPAM operator.
+// The assigned address is persisted in the IP object, both in its status and in an
+// annotation, so the state survives restarts even if the status subresource is enabled.
+// The lock only serialises the allocations of one process, so a single instance may run in standalone mode.
+type standaloneAllocator struct {
+	blendedAllocator
+	lock sync.Mutex
+}
+
+// NewStandaloneAllocator creates an allocator which picks free addresses from the pool itself.
+func NewStandaloneAllocator(blendedset blended.Interface, keys *constants.Keys) Allocator {
+	return &standaloneAllocator{blendedAllocator: blendedAllocator{blendedset: blendedset, keys: keys}}
+}
+
+func (a *standaloneAllocator) Allocate(req *Request) (*blendedv1.IP, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	pool, err := a.Pool(req.Pool)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := a.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	for _, ip := range ips.Items {
+		if ip.Spec.PoolName == pool.Name && ip.Status.Address != "" {
+			used[ip.Status.Address] = true
+		}
+	}
+
+	address, err := RequestAddress(pool, used, req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := &blendedv1.IP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        req.Name,
+			Namespace:   req.Owner.Namespace,
+			Labels:      requestLabels(a.keys, req),
+			Annotations: map[string]string{a.keys.StandaloneAddressKey: address},
+		},
+		Spec: blendedv1.IPSpec{
+			PoolName: req.Pool,
+		},
+		Status: blendedv1.IPStatus{
+			Phase:          blendedv1.IPActive,
+			Address:        address,
+			LastUpdateTime: metav1.Now(),
+		},
+	}
+
+	created, err := a.blendedset.InwinstackV1().IPs(ip.Namespace).Create(ip)
+	if err != nil {
+		return nil, err
+	}
+	fillStatus(a.keys, created)
+	return created, nil
+}
+
+func (a *standaloneAllocator) Update(ip *blendedv1.IP) (*blendedv1.IP, error) {
+	updated, err := a.blendedAllocator.Update(ip)
+	if err != nil {
+		return nil, err
+	}
+	fillStatus(a.keys, updated)
+	return updated, nil
+}
+
+func (a *standaloneAllocator) Get(namespace, name string) (*blendedv1.IP, error) {
+	ip, err := a.blendedAllocator.Get(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	fillStatus(a.keys, ip)
+	return ip, nil
+}
+
+func (a *standaloneAllocator) List(namespace string) (*blendedv1.IPList, error) {
+	ips, err := a.blendedAllocator.List(namespace)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ips.Items {
+		fillStatus(a.keys, &ips.Items[i])
+	}
+	return ips, nil
+}
+
+func (a *standaloneAllocator) ListByOwner(owner Owner) (*blendedv1.IPList, error) {
+	ips, err := a.blendedAllocator.ListByOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ips.Items {
+		fillStatus(a.keys, &ips.Items[i])
+	}
+	return ips, nil
+}
+
+// fillStatus restores the status from the annotation, when the status was not persisted.
+func fillStatus(keys *constants.Keys, ip *blendedv1.IP) {
+	address, ok := ip.Annotations[keys.StandaloneAddressKey]
+	if !ok || ip.Status.Address != "" {
+		return
+	}
+	ip.Status.Address = address
+	ip.Status.Phase = blendedv1.IPActive
+	ip.Status.LastUpdateTime = ip.CreationTimestamp
+}
@@ -0,0 +1,58 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStandaloneAllocator(t *testing.T) {
+	blendedset := blendedfake.NewSimpleClientset()
+	_, err := blendedset.InwinstackV1().Pools().Create(newPool("default", "172.22.132.10-172.22.132.11"))
+	assert.Nil(t, err)
+
+	testAllocator(t, NewStandaloneAllocator(blendedset, constants.DefaultKeys))
+
+	// The address of ip1 was released, so it must be reused.
+	allocator := NewStandaloneAllocator(blendedset, constants.DefaultKeys)
+	owner := Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"}
+	ip, err := allocator.Allocate(&Request{Name: "ip3", Pool: "default", Owner: owner})
+	assert.Nil(t, err)
+	assert.Equal(t, blendedv1.IPActive, ip.Status.Phase)
+	assert.Equal(t, "172.22.132.10", ip.Status.Address)
+
+	_, err = allocator.Allocate(&Request{Name: "ip4", Pool: "default", Owner: owner})
+	assert.NotNil(t, err)
+}
+
+func TestFillStatus(t *testing.T) {
+	ip := &blendedv1.IP{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{constants.StandaloneAddressKey: "172.22.132.10"},
+		},
+	}
+	fillStatus(constants.DefaultKeys, ip)
+	assert.Equal(t, "172.22.132.10", ip.Status.Address)
+	assert.Equal(t, blendedv1.IPActive, ip.Status.Phase)
+}
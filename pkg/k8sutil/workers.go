@@ -0,0 +1,61 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/inwinstack/ip-assigner/pkg/health"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Workers runs the goroutines of a controller, so that its in-flight reconciles can be drained on shutdown.
+type Workers struct {
+	name    string
+	tracker *health.Tracker
+	wg      sync.WaitGroup
+}
+
+// NewWorkers creates the workers of a controller, the tracker is nil for a controller without a queue.
+func NewWorkers(name string, tracker *health.Tracker) *Workers {
+	return &Workers{name: name, tracker: tracker}
+}
+
+// Go calls f every period until the context is done
+func (w *Workers) Go(ctx context.Context, f func(), period time.Duration) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		wait.Until(f, period, ctx.Done())
+	}()
+}
+
+// Wait waits for the in-flight reconciles to finish, the keys which are still reconciled after the timeout are abandoned.
+func (w *Workers) Wait(timeout time.Duration) {
+	if WaitTimeout(&w.wg, timeout) {
+		return
+	}
+	if w.tracker != nil {
+		glog.Warningf("%s abandoned %s after %s", w.name, strings.Join(w.tracker.InFlight(), ","), timeout)
+		return
+	}
+	glog.Warningf("%s abandoned after %s", w.name, timeout)
+}
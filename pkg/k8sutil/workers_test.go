@@ -0,0 +1,61 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8sutil
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inwinstack/ip-assigner/pkg/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkers(t *testing.T) {
+	tracker := health.NewTracker("test", func() int { return 0 }, func() bool { return true })
+	workers := NewWorkers(tracker.Name(), tracker)
+
+	var calls int32
+	ctx, cancel := context.WithCancel(context.Background())
+	workers.Go(ctx, func() { atomic.AddInt32(&calls, 1) }, time.Millisecond)
+	time.Sleep(time.Millisecond * 20)
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait(time.Second)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("workers were not drained")
+	}
+	assert.True(t, atomic.LoadInt32(&calls) > 0)
+
+	// A worker which does not return is abandoned after the timeout.
+	block := make(chan struct{})
+	defer close(block)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	tracker.Start("busy")
+	workers.Go(ctx, func() { <-block }, time.Millisecond)
+	start := time.Now()
+	workers.Wait(time.Millisecond * 10)
+	assert.True(t, time.Since(start) < time.Second)
+}
@@ -0,0 +1,237 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+)
+
+const (
+	// SignatureHeader is the header of the HMAC-SHA256 signature of the payload.
+	SignatureHeader = "X-IP-Assigner-Signature"
+	// DeliveryHeader is the header of the unique ID of a notification, it is the same for retries.
+	DeliveryHeader = "X-IP-Assigner-Delivery"
+
+	defaultTimeout = time.Second * 10
+	minBackoff     = time.Second
+	maxBackoff     = time.Minute * 5
+)
+
+// Payload represents the body of a notification
+type Payload struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	OwnerKind string    `json:"ownerKind"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name"`
+	Pool      string    `json:"pool,omitempty"`
+	Address   string    `json:"address,omitempty"`
+}
+
+type notification struct {
+	ID       string   `json:"id"`
+	Endpoint string   `json:"endpoint"`
+	Payload  *Payload `json:"payload"`
+
+	attempts int
+	next     time.Time
+}
+
+// Notifier posts signed allocation changes to HTTP endpoints. It implements audit.Sink, the
+// notifications are queued and delivered in order per endpoint until the endpoint accepts them.
+type Notifier struct {
+	sync.Mutex
+	endpoints []string
+	secret    []byte
+	queue     *queue
+	client    *http.Client
+	wakeup    chan struct{}
+	seq       uint64
+}
+
+// New creates a notifier, the queue is persisted in the directory unless it is empty.
+func New(endpoints []string, secret string, dir string) (*Notifier, error) {
+	q, err := newQueue(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{
+		endpoints: endpoints,
+		secret:    []byte(secret),
+		queue:     q,
+		client:    &http.Client{Timeout: defaultTimeout},
+		wakeup:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Sign returns the signature of the body, receivers compare it with the signature header.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Record queues a notification of the event for each endpoint, only assignments and releases are notified.
+func (n *Notifier) Record(e *audit.Event) error {
+	if e.Action != audit.ActionAssign && e.Action != audit.ActionRelease {
+		return nil
+	}
+
+	payload := &Payload{
+		Time:      e.Time,
+		Action:    e.Action,
+		OwnerKind: e.OwnerKind,
+		Namespace: e.Namespace,
+		Name:      e.Name,
+		Pool:      e.Pool,
+		Address:   e.Address,
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	for _, endpoint := range n.endpoints {
+		n.seq++
+		id := fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), n.seq)
+		if err := n.queue.push(&notification{ID: id, Endpoint: endpoint, Payload: payload}); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case n.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len returns the number of undelivered notifications
+func (n *Notifier) Len() int {
+	n.Lock()
+	defer n.Unlock()
+	return n.queue.len()
+}
+
+// Run delivers the queued notifications until the stop channel is closed
+func (n *Notifier) Run(stopCh <-chan struct{}) {
+	glog.Info("Starting notifier")
+	for {
+		wait := n.deliver()
+		timer := time.NewTimer(wait)
+		select {
+		case <-stopCh:
+			timer.Stop()
+			glog.Info("Stopping notifier")
+			return
+		case <-n.wakeup:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// deliver sends the due notifications, and returns how long to wait for the next one.
+func (n *Notifier) deliver() time.Duration {
+	wait := maxBackoff
+	for _, item := range n.heads() {
+		if d := time.Until(item.next); d > 0 {
+			if d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		if err := n.post(item); err != nil {
+			item.attempts++
+			backoff := minBackoff << uint(item.attempts-1)
+			if backoff > maxBackoff || backoff <= 0 {
+				backoff = maxBackoff
+			}
+			item.next = time.Now().Add(backoff)
+			glog.Warningf("Failed to notify '%s' of %s '%s' (attempt %d), retrying in %s: %s",
+				item.Endpoint, item.Payload.Action, item.Payload.Address, item.attempts, backoff, err.Error())
+			if backoff < wait {
+				wait = backoff
+			}
+			continue
+		}
+
+		n.Lock()
+		if err := n.queue.remove(item); err != nil {
+			glog.Errorf("Failed to remove delivered notification '%s': %s", item.ID, err.Error())
+		}
+		n.Unlock()
+		// The next notification of the endpoint can be sent right away.
+		wait = 0
+	}
+	return wait
+}
+
+// heads returns the oldest notification of each endpoint, so that an endpoint receives them in order.
+func (n *Notifier) heads() []*notification {
+	n.Lock()
+	defer n.Unlock()
+
+	seen := map[string]bool{}
+	var heads []*notification
+	for _, item := range n.queue.items {
+		if !seen[item.Endpoint] {
+			seen[item.Endpoint] = true
+			heads = append(heads, item)
+		}
+	}
+	return heads
+}
+
+func (n *Notifier) post(item *notification) error {
+	body, err := json.Marshal(item.Payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, item.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, item.ID)
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
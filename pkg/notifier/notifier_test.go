@@ -0,0 +1,137 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+const timeout = time.Second * 5
+
+var event = &audit.Event{
+	Time:      time.Now().UTC(),
+	Action:    audit.ActionAssign,
+	OwnerKind: "Service",
+	Namespace: "test",
+	Name:      "svc",
+	Pool:      "internet",
+	IP:        "172.22.132.10",
+	Address:   "140.11.22.33",
+}
+
+func waitForEmpty(t *testing.T, n *Notifier) {
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(time.Millisecond * 10) {
+		if n.Len() == 0 {
+			return
+		}
+	}
+	t.Fatal("notifications were not delivered")
+}
+
+func TestNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var got []Payload
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Fail the first request to check the retry.
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, Sign([]byte("secret"), body), r.Header.Get(SignatureHeader))
+		assert.NotEmpty(t, r.Header.Get(DeliveryHeader))
+
+		var p Payload
+		assert.Nil(t, json.Unmarshal(body, &p))
+		got = append(got, p)
+	}))
+	defer server.Close()
+
+	n, err := New([]string{server.URL}, "secret", "")
+	assert.Nil(t, err)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go n.Run(stopCh)
+
+	// Retained IPs are not notified.
+	assert.Nil(t, n.Record(&audit.Event{Action: audit.ActionRetain}))
+	assert.Nil(t, n.Record(event))
+	release := *event
+	release.Action = audit.ActionRelease
+	assert.Nil(t, n.Record(&release))
+	waitForEmpty(t, n)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, calls)
+	assert.Len(t, got, 2)
+	assert.Equal(t, audit.ActionAssign, got[0].Action)
+	assert.Equal(t, audit.ActionRelease, got[1].Action)
+	assert.Equal(t, "140.11.22.33", got[1].Address)
+}
+
+func TestPersistentQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifier")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Queue a notification without delivering it, e.g. the operator was restarted.
+	n, err := New([]string{"http://127.0.0.1:0"}, "", dir)
+	assert.Nil(t, err)
+	assert.Nil(t, n.Record(event))
+	assert.Equal(t, 1, n.Len())
+
+	var got Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer server.Close()
+
+	// Point the stored notification to the test server.
+	n, err = New(nil, "", dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n.Len())
+	n.queue.items[0].Endpoint = server.URL
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go n.Run(stopCh)
+	waitForEmpty(t, n)
+	assert.Equal(t, event.Address, got.Address)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 0)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+)
+
+const queueFileSuffix = ".json"
+
+// queue keeps the undelivered notifications in order, each one is stored as a file
+// of the directory so that they survive restarts.
+type queue struct {
+	dir   string
+	items []*notification
+}
+
+func newQueue(dir string) (*queue, error) {
+	q := &queue{dir: dir}
+	if dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), queueFileSuffix) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		item := &notification{}
+		if err := json.Unmarshal(data, item); err != nil {
+			return nil, err
+		}
+		q.items = append(q.items, item)
+	}
+	return q, nil
+}
+
+func (q *queue) len() int {
+	return len(q.items)
+}
+
+func (q *queue) path(item *notification) string {
+	return filepath.Join(q.dir, item.ID+queueFileSuffix)
+}
+
+func (q *queue) push(item *notification) error {
+	if q.dir != "" {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+
+		if err := k8sutil.WriteFileAtomic(q.path(item), data); err != nil {
+			return err
+		}
+	}
+	q.items = append(q.items, item)
+	return nil
+}
+
+func (q *queue) remove(item *notification) error {
+	for i, it := range q.items {
+		if it == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+
+	if q.dir == "" {
+		return nil
+	}
+	if err := os.Remove(q.path(item)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/inwinstack/ip-assigner/pkg/operator/ipclaim"
+	"github.com/inwinstack/ip-assigner/pkg/operator/service"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	informerv1 "k8s.io/client-go/informers/core/v1"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Controller represents the garbage collector of orphaned IPs. An IP is orphaned when
+// it was allocated by ip-assigner, and its owner no longer exists or no longer references it.
+type Controller struct {
+	cfg  *config.Config
+	keys *constants.Keys
+
+	dynamicset dynamic.Interface
+	allocator  k8sutil.Allocator
+	sink       audit.Sink
+	nsLister   listerv1.NamespaceLister
+	svcLister  listerv1.ServiceLister
+	synced     []cache.InformerSynced
+	workers    *k8sutil.Workers
+	cancel     context.CancelFunc
+
+	// orphans records when each orphaned IP was found, so that it is deleted after the grace period.
+	mu      sync.Mutex
+	orphans map[string]time.Time
+}
+
+// NewController creates an instance of the garbage collector
+func NewController(
+	cfg *config.Config,
+	dynamicset dynamic.Interface,
+	allocator k8sutil.Allocator,
+	sink audit.Sink,
+	nsInformer informerv1.NamespaceInformer,
+	svcInformer informerv1.ServiceInformer) *Controller {
+	return &Controller{
+		cfg:        cfg,
+		keys:       cfg.Keys(),
+		dynamicset: dynamicset,
+		allocator:  allocator,
+		sink:       sink,
+		nsLister:   nsInformer.Lister(),
+		svcLister:  svcInformer.Lister(),
+		synced:     []cache.InformerSynced{nsInformer.Informer().HasSynced, svcInformer.Informer().HasSynced},
+		workers:    k8sutil.NewWorkers("IP garbage collection", nil),
+		orphans:    map[string]time.Time{},
+	}
+}
+
+// Run serves the garbage collector
+func (c *Controller) Run(ctx context.Context) error {
+	glog.Info("Starting IP garbage collector")
+	glog.Info("Waiting for IP garbage collector informer caches to sync")
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced...); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.workers.Go(ctx, c.collect, c.cfg.GCInterval)
+	return nil
+}
+
+// Stop stops the garbage collector, a running collection is finished but no new one is started.
+func (c *Controller) Stop() {
+	glog.Info("Stopping IP garbage collector")
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// Wait waits for a running collection to finish
+func (c *Controller) Wait(timeout time.Duration) {
+	c.workers.Wait(timeout)
+}
+
+func (c *Controller) collect() {
+	ips, err := c.allocator.List("")
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	refs, err := c.serviceRefs()
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found := map[string]bool{}
+	for i := range ips.Items {
+		ip := &ips.Items[i]
+		if ip.Labels[c.keys.ManagedByLabel] != constants.ManagedByValue || ip.Labels[c.keys.RetainedLabel] == "true" {
+			continue
+		}
+
+		orphaned, reason, err := c.orphaned(ip, refs)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+
+		key := ip.Namespace + "/" + ip.Name
+		found[key] = true
+		since, ok := c.orphans[key]
+		if !ok {
+			since = time.Now()
+			c.orphans[key] = since
+			glog.Warningf("IP garbage collector found orphaned IP '%s' (%s): %s", key, ip.Status.Address, reason)
+		}
+
+		if time.Since(since) < c.cfg.GCGracePeriod {
+			continue
+		}
+
+		// Deleting is opt-in, by default the orphaned IPs are only reported.
+		if !c.cfg.GCDelete {
+			glog.Warningf("IP garbage collector would delete orphaned IP '%s' (%s), orphaned since %s: %s",
+				key, ip.Status.Address, since.Format(time.RFC3339), reason)
+			continue
+		}
+
+		if err := c.allocator.Release(ip); err != nil && !errors.IsNotFound(err) {
+			utilruntime.HandleError(err)
+			continue
+		}
+		glog.Warningf("IP garbage collector deleted orphaned IP '%s' (%s): %s", key, ip.Status.Address, reason)
+		audit.Record(c.sink, &audit.Event{
+			Action:    audit.ActionRelease,
+			OwnerKind: ip.Labels[c.keys.OwnerKindLabel],
+			Namespace: ip.Namespace,
+			Name:      ip.Labels[c.keys.OwnerNameLabel],
+			Pool:      ip.Spec.PoolName,
+			IP:        ip.Name,
+			Address:   ip.Status.Address,
+			Reason:    "orphaned: " + reason,
+		})
+		delete(found, key)
+	}
+
+	// Forget the IPs which were deleted or adopted by their owners again.
+	for key := range c.orphans {
+		if !found[key] {
+			delete(c.orphans, key)
+		}
+	}
+}
+
+// serviceRefs returns the IPs which are referenced by Services. The names are normalised by the
+// allocator, so that they match the names of the listed IPs.
+func (c *Controller) serviceRefs() (map[string]bool, error) {
+	svcs, err := c.svcLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]bool{}
+	for _, svc := range svcs {
+		if namespace, name := service.IPRef(c.keys, svc); name != "" {
+			refs[namespace+"/"+c.allocator.Name(name)] = true
+		}
+		if name := svc.Annotations[c.keys.MigrationPublicIPRefKey]; name != "" {
+			refs[svc.Namespace+"/"+c.allocator.Name(name)] = true
+		}
+	}
+	return refs, nil
+}
+
+// orphaned reports whether the owner of the IP no longer exists or no longer references it.
+func (c *Controller) orphaned(ip *blendedv1.IP, refs map[string]bool) (bool, string, error) {
+	kind := ip.Labels[c.keys.OwnerKindLabel]
+	name := ip.Labels[c.keys.OwnerNameLabel]
+	switch kind {
+	case constants.OwnerKindNamespace:
+		if _, err := c.nsLister.Get(name); err != nil {
+			if errors.IsNotFound(err) {
+				return true, fmt.Sprintf("Namespace '%s' no longer exists", name), nil
+			}
+			return false, "", err
+		}
+	case constants.OwnerKindService:
+		if !refs[ip.Namespace+"/"+ip.Name] {
+			return true, fmt.Sprintf("no Service references it, owner was Service '%s/%s'", ip.Namespace, name), nil
+		}
+	case constants.OwnerKindIPClaim:
+		// The claims cannot be checked when the IPClaim CRD may not be installed.
+		if !c.cfg.EnableIPClaims {
+			return false, "", nil
+		}
+		_, err := c.dynamicset.Resource(ipclaim.Resource).Namespace(ip.Namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return true, fmt.Sprintf("IPClaim '%s/%s' no longer exists", ip.Namespace, name), nil
+			}
+			return false, "", err
+		}
+	}
+	return false, "", nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gc
+
+import (
+	"testing"
+	"time"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newController(cfg *config.Config, allocator k8sutil.Allocator, objs ...interface{}) *Controller {
+	informer := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	nsInformer := informer.Core().V1().Namespaces()
+	svcInformer := informer.Core().V1().Services()
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *corev1.Namespace:
+			nsInformer.Informer().GetIndexer().Add(obj)
+		case *corev1.Service:
+			svcInformer.Informer().GetIndexer().Add(obj)
+		}
+	}
+	return NewController(cfg, nil, allocator, nil, nsInformer, svcInformer)
+}
+
+func TestCollect(t *testing.T) {
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.20"}},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+
+	allocate := func(name string, owner k8sutil.Owner) *blendedv1.IP {
+		ip, err := allocator.Allocate(&k8sutil.Request{Name: name, Pool: pool.Name, Owner: owner})
+		assert.Nil(t, err)
+		return ip
+	}
+
+	// IPs of an existing Namespace and a Service which references its IP are kept.
+	allocate("ns-ip", k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"})
+	allocate("172.22.132.100", k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: "test", Name: "svc"})
+	// IPs of a deleted Namespace and a deleted Service are orphaned.
+	allocate("deleted-ns-ip", k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: "deleted", Name: "deleted"})
+	allocate("172.22.132.101", k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: "test", Name: "deleted"})
+	// Retained IPs are kept for re-attaching.
+	retained := allocate("172.22.132.102", k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: "test", Name: "retained"})
+	retained.Labels[constants.RetainedLabel] = "true"
+	_, err := allocator.Update(retained)
+	assert.Nil(t, err)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "test"},
+		Spec:       corev1.ServiceSpec{ExternalIPs: []string{"172.22.132.100"}},
+	}
+
+	cfg := &config.Config{GCGracePeriod: time.Hour}
+	controller := newController(cfg, allocator, ns, svc)
+
+	// Nothing is deleted in the grace period or without opting in.
+	controller.collect()
+	assert.Len(t, controller.orphans, 2)
+	ips, err := allocator.List("")
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 5)
+
+	for key := range controller.orphans {
+		controller.orphans[key] = time.Now().Add(-2 * time.Hour)
+	}
+	controller.collect()
+	ips, err = allocator.List("")
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 5)
+
+	cfg.GCDelete = true
+	controller.collect()
+	ips, err = allocator.List("")
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 3)
+	assert.Len(t, controller.orphans, 0)
+
+	_, err = allocator.Get("deleted", "deleted-ns-ip")
+	assert.NotNil(t, err)
+	_, err = allocator.Get("test", "172.22.132.101")
+	assert.NotNil(t, err)
+}
+
+func TestCollectWithClusterAllocator(t *testing.T) {
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.20"}},
+	}
+	allocator := k8sutil.NewClusterAllocator(k8sutil.NewMemoryAllocator(pool), "east", constants.DefaultKeys)
+
+	// The IPs of a Service which is migrating are named by its external IP and the new pool.
+	owner := k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: "test", Name: "svc"}
+	for _, name := range []string{"172.22.132.100", "172.22.132.100-internet"} {
+		_, err := allocator.Allocate(&k8sutil.Request{Name: name, Pool: pool.Name, Owner: owner})
+		assert.Nil(t, err)
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "svc",
+			Namespace:   "test",
+			Annotations: map[string]string{constants.MigrationPublicIPRefKey: "172.22.132.100-internet"},
+		},
+		Spec: corev1.ServiceSpec{ExternalIPs: []string{"172.22.132.100"}},
+	}
+
+	controller := newController(&config.Config{GCDelete: true}, allocator, svc)
+	controller.collect()
+	assert.Len(t, controller.orphans, 0)
+
+	ips, err := allocator.List("")
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 2)
+}
@@ -0,0 +1,434 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipclaim
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	blended_k8sutil "github.com/inwinstack/blended/k8sutil"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/health"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Controller represents the controller of IPClaim
+type Controller struct {
+	cfg  *config.Config
+	keys *constants.Keys
+
+	dynamicset dynamic.Interface
+	allocator  k8sutil.Allocator
+	sink       audit.Sink
+	lister     cache.GenericLister
+	synced     cache.InformerSynced
+	queue      workqueue.RateLimitingInterface
+	workers    *k8sutil.Workers
+	tracker    *health.Tracker
+}
+
+// NewController creates an instance of the IPClaim controller
+func NewController(
+	cfg *config.Config,
+	dynamicset dynamic.Interface,
+	allocator k8sutil.Allocator,
+	sink audit.Sink,
+	informer informers.GenericInformer) *Controller {
+	controller := &Controller{
+		cfg:        cfg,
+		keys:       cfg.Keys(),
+		dynamicset: dynamicset,
+		allocator:  allocator,
+		sink:       sink,
+		lister:     informer.Lister(),
+		synced:     informer.Informer().HasSynced,
+		queue:      workqueue.NewNamedRateLimitingQueue(k8sutil.NewRateLimiter(cfg.Controller(config.IPClaimController)), "IPClaims"),
+	}
+	controller.tracker = health.NewTracker(cfg.ScopedName("IPClaims"), controller.queue.Len, controller.synced)
+	controller.workers = k8sutil.NewWorkers(controller.tracker.Name(), controller.tracker)
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueue(new)
+		},
+	})
+	return controller
+}
+
+// Run serves the IPClaim controller
+func (c *Controller) Run(ctx context.Context, threadiness int) error {
+	glog.Info("Starting IPClaim controller")
+	glog.Info("Waiting for IPClaim informer caches to sync")
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	for i := 0; i < threadiness; i++ {
+		c.workers.Go(ctx, c.runWorker, time.Second)
+	}
+	return nil
+}
+
+// Stop stops the IPClaim controller
+func (c *Controller) Stop() {
+	glog.Info("Stopping the IPClaim controller")
+	c.queue.ShutDown()
+}
+
+// Wait waits for the in-flight reconciles to finish, the keys which are still reconciled after the timeout are abandoned.
+func (c *Controller) Wait(timeout time.Duration) {
+	c.workers.Wait(timeout)
+}
+
+// Tracker returns the tracker of the IPClaim controller
+func (c *Controller) Tracker() *health.Tracker {
+	return c.tracker
+}
+
+func (c *Controller) runWorker() {
+	defer utilruntime.HandleCrash()
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+
+	// The queued keys are dropped once the controller is stopped.
+	if c.queue.ShuttingDown() {
+		c.queue.Done(obj)
+		return false
+	}
+
+	err := func(obj interface{}) error {
+		defer c.queue.Done(obj)
+		key, ok := obj.(string)
+		if !ok {
+			c.queue.Forget(obj)
+			utilruntime.HandleError(fmt.Errorf("IPClaim controller expected string in workqueue but got %#v", obj))
+			return nil
+		}
+
+		c.tracker.Start(key)
+		err := c.reconcile(key)
+		c.tracker.Finish(key, err)
+		if err != nil {
+			c.queue.AddRateLimited(key)
+			return fmt.Errorf("IPClaim controller error syncing '%s': %s, requeuing", key, err.Error())
+		}
+
+		c.queue.Forget(obj)
+		glog.V(2).Infof("IPClaim controller successfully synced '%s'", key)
+		return nil
+	}(obj)
+
+	if err != nil {
+		utilruntime.HandleError(err)
+		return true
+	}
+	return true
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	// The objects of other assigner classes are handled by other instances.
+	if m, err := meta.Accessor(obj); err == nil && !c.cfg.Handles(m.GetAnnotations()) {
+		return
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *Controller) reconcile(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return err
+	}
+
+	obj, err := c.lister.ByNamespace(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			glog.V(3).Infof("IPClaim '%s' in work queue no longer exists", key)
+			return nil
+		}
+		return err
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("IPClaim controller expected unstructured object but got %#v", obj)
+	}
+
+	claim, err := FromUnstructured(u.DeepCopy())
+	if err != nil {
+		return err
+	}
+
+	if !claim.ObjectMeta.DeletionTimestamp.IsZero() {
+		return c.cleanup(claim)
+	}
+
+	if !funk.ContainsString(claim.Finalizers, constants.Finalizer) {
+		return c.update(claim, func(latest *IPClaim) {
+			blended_k8sutil.AddFinalizer(&latest.ObjectMeta, constants.Finalizer)
+		})
+	}
+
+	ips, err := c.syncIPs(claim)
+	if err != nil {
+		return err
+	}
+	return c.updateStatus(claim, ips)
+}
+
+func owner(claim *IPClaim) k8sutil.Owner {
+	return k8sutil.Owner{Kind: constants.OwnerKindIPClaim, Namespace: claim.Namespace, Name: claim.Name}
+}
+
+// ipName returns the name of IP object for the index of a claim. A Namespace name has no dots, and the
+// Services name their IPs after addresses or sharing keys, so the IPs of a claim never take their names.
+func ipName(claim *IPClaim, index int) string {
+	return fmt.Sprintf("claim.%s-%d", claim.Name, index)
+}
+
+// ipIndex returns the index of IP object of a claim
+func ipIndex(ip *blendedv1.IP) int {
+	index, err := strconv.Atoi(ip.Name[strings.LastIndex(ip.Name, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return index
+}
+
+func (c *Controller) syncIPs(claim *IPClaim) ([]*blendedv1.IP, error) {
+	number := claim.Number()
+	ips := make([]*blendedv1.IP, 0, number)
+	for i := 0; i < number; i++ {
+		ip, err := c.allocator.Get(claim.Namespace, ipName(claim, i))
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+
+		// Move the IP when the pool of the claim was changed.
+		if err == nil && ip.Spec.PoolName != claim.Spec.Pool {
+			if err := c.allocator.Release(ip); err != nil {
+				return nil, err
+			}
+			audit.Record(c.sink, event(claim, audit.ActionPoolSwitch, ip, fmt.Sprintf("moving to pool '%s'", claim.Spec.Pool)))
+			return nil, fmt.Errorf("IP '%s' has been released for moving to pool '%s'", ip.Name, claim.Spec.Pool)
+		}
+
+		if err == nil {
+			// A retained IP of a re-created claim is taken over.
+			if ip.Labels[c.keys.RetainedLabel] == "true" {
+				ipCopy := ip.DeepCopy()
+				delete(ipCopy.Labels, c.keys.RetainedLabel)
+				if ip, err = c.allocator.Patch(ip, ipCopy); err != nil {
+					return nil, err
+				}
+			}
+			ips = append(ips, ip)
+			continue
+		}
+
+		req := &k8sutil.Request{
+			Name:  ipName(claim, i),
+			Pool:  claim.Spec.Pool,
+			Owner: owner(claim),
+		}
+		if len(claim.Spec.Addresses) > 0 {
+			req.Address = claim.Spec.Addresses[i]
+		}
+
+		ip, err = c.allocator.Allocate(req)
+		if err != nil {
+			return nil, err
+		}
+		audit.Record(c.sink, event(claim, audit.ActionAllocate, ip, "claimed"))
+		ips = append(ips, ip)
+	}
+
+	// Release the IPs which are no longer claimed.
+	owned, err := c.allocator.ListByOwner(owner(claim))
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range owned.Items {
+		if ipIndex(&ip) >= number || ipIndex(&ip) < 0 {
+			if err := c.allocator.Release(&ip); err != nil {
+				return nil, err
+			}
+			audit.Record(c.sink, event(claim, audit.ActionRelease, &ip, "no longer claimed"))
+		}
+	}
+	return ips, nil
+}
+
+func event(claim *IPClaim, action string, ip *blendedv1.IP, reason string) *audit.Event {
+	return &audit.Event{
+		Action:    action,
+		OwnerKind: constants.OwnerKindIPClaim,
+		Namespace: claim.Namespace,
+		Name:      claim.Name,
+		Pool:      ip.Spec.PoolName,
+		IP:        ip.Name,
+		Address:   ip.Status.Address,
+		Reason:    reason,
+	}
+}
+
+// newStatus returns the status of the claim for the IPs
+func newStatus(claim *IPClaim, ips []*blendedv1.IP) IPClaimStatus {
+	var addrs, pending, failed, mismatched []string
+	for i, ip := range ips {
+		if ip.Status.Phase == blendedv1.IPFailed {
+			failed = append(failed, ip.Name)
+			continue
+		}
+
+		addr := net.ParseIP(ip.Status.Address)
+		if addr == nil {
+			pending = append(pending, ip.Name)
+			continue
+		}
+
+		addrs = append(addrs, addr.String())
+		if len(claim.Spec.Addresses) > i && net.ParseIP(claim.Spec.Addresses[i]).String() != addr.String() {
+			mismatched = append(mismatched, claim.Spec.Addresses[i])
+		}
+	}
+
+	cond := IPClaimCondition{Type: ConditionReady, Status: v1.ConditionTrue, Reason: "Allocated"}
+	switch {
+	case len(failed) > 0:
+		cond.Status, cond.Reason = v1.ConditionFalse, "AllocationFailed"
+		cond.Message = fmt.Sprintf("Failed to allocate IPs: %s", strings.Join(failed, ","))
+	case len(pending) > 0:
+		cond.Status, cond.Reason = v1.ConditionFalse, "Pending"
+		cond.Message = fmt.Sprintf("Waiting for IPs: %s", strings.Join(pending, ","))
+	case len(mismatched) > 0:
+		cond.Status, cond.Reason = v1.ConditionFalse, "AddressMismatch"
+		cond.Message = fmt.Sprintf("IPAM did not assign the requested addresses: %s", strings.Join(mismatched, ","))
+	}
+
+	cond.LastTransitionTime = metav1.Now()
+	for _, c := range claim.Status.Conditions {
+		if c.Type == cond.Type && c.Status == cond.Status {
+			cond.LastTransitionTime = c.LastTransitionTime
+		}
+	}
+	return IPClaimStatus{Addresses: addrs, Conditions: []IPClaimCondition{cond}}
+}
+
+func (c *Controller) updateStatus(claim *IPClaim, ips []*blendedv1.IP) error {
+	status := newStatus(claim, ips)
+	if !reflect.DeepEqual(status, claim.Status) {
+		claimCopy := claim.DeepCopy()
+		claimCopy.Status = status
+		u, err := ToUnstructured(claimCopy)
+		if err != nil {
+			return err
+		}
+		if _, err := c.dynamicset.Resource(Resource).Namespace(claim.Namespace).UpdateStatus(u, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+	}
+
+	if status.Conditions[0].Reason == "Pending" {
+		return fmt.Errorf("IPClaim '%s/%s' is waiting for addresses", claim.Namespace, claim.Name)
+	}
+	return nil
+}
+
+// update applies the change to the latest version of the claim, and retries it on conflicts. Since the change is
+// made on a fresh Get, the fields which were changed by others meanwhile are kept.
+func (c *Controller) update(claim *IPClaim, change func(*IPClaim)) error {
+	claims := c.dynamicset.Resource(Resource).Namespace(claim.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		u, err := claims.Get(claim.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		latest, err := FromUnstructured(u)
+		if err != nil {
+			return err
+		}
+		change(latest)
+		if u, err = ToUnstructured(latest); err != nil {
+			return err
+		}
+		_, err = claims.Update(u, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (c *Controller) cleanup(claim *IPClaim) error {
+	ips, err := c.allocator.ListByOwner(owner(claim))
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips.Items {
+		if claim.Spec.RetainPolicy == constants.RetainPolicyRetain {
+			ipCopy := ip.DeepCopy()
+			ipCopy.Labels[c.keys.RetainedLabel] = "true"
+			if _, err := c.allocator.Patch(&ip, ipCopy); err != nil {
+				return err
+			}
+			audit.Record(c.sink, event(claim, audit.ActionRetain, ipCopy, "claim deleted"))
+			continue
+		}
+
+		if err := c.allocator.Release(&ip); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		audit.Record(c.sink, event(claim, audit.ActionRelease, &ip, "claim deleted"))
+	}
+
+	return c.update(claim, func(latest *IPClaim) {
+		blended_k8sutil.RemoveFinalizer(&latest.ObjectMeta, constants.Finalizer)
+	})
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipclaim
+
+import (
+	"testing"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newClaim(count int, addresses ...string) *IPClaim {
+	return &IPClaim{
+		TypeMeta: metav1.TypeMeta{APIVersion: "inwinstack.com/v1", Kind: "IPClaim"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vm",
+			Namespace: "test",
+		},
+		Spec: IPClaimSpec{
+			Pool:      "default",
+			Count:     count,
+			Addresses: addresses,
+		},
+	}
+}
+
+func newTestController(allocator k8sutil.Allocator) *Controller {
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	informer := dynamicinformer.NewDynamicSharedInformerFactory(dynamicset, 0)
+	return NewController(&config.Config{}, dynamicset, allocator, nil, informer.ForResource(Resource))
+}
+
+func TestConvertIPClaim(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "inwinstack.com/v1",
+		"kind":       "IPClaim",
+		"metadata": map[string]interface{}{
+			"name":      "vm",
+			"namespace": "test",
+		},
+		"spec": map[string]interface{}{
+			"pool":      "default",
+			"addresses": []interface{}{"172.22.132.10"},
+		},
+	}}
+
+	claim, err := FromUnstructured(u)
+	assert.Nil(t, err)
+	assert.Equal(t, "default", claim.Spec.Pool)
+	assert.Equal(t, 1, claim.Number())
+
+	converted, err := ToUnstructured(claim)
+	assert.Nil(t, err)
+	assert.Equal(t, "vm", converted.GetName())
+	assert.Equal(t, "IPClaim", converted.GetKind())
+}
+
+func TestSyncIPs(t *testing.T) {
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.15"}},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	controller := newTestController(allocator)
+
+	// Specific addresses
+	claim := newClaim(0, "172.22.132.12", "172.22.132.14")
+	ips, err := controller.syncIPs(claim)
+	assert.Nil(t, err)
+
+	status := newStatus(claim, ips)
+	assert.Equal(t, []string{"172.22.132.12", "172.22.132.14"}, status.Addresses)
+	assert.Equal(t, v1.ConditionTrue, status.Conditions[0].Status)
+
+	// Scale down to one address
+	claim = newClaim(1)
+	ips, err = controller.syncIPs(claim)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ips))
+
+	list, err := allocator.List("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(list.Items))
+	assert.Equal(t, "claim.vm-0", list.Items[0].Name)
+}
+
+func TestNewStatus(t *testing.T) {
+	claim := newClaim(0, "172.22.132.12")
+	ips := []*blendedv1.IP{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "claim.vm-0"},
+			Status:     blendedv1.IPStatus{Phase: blendedv1.IPActive, Address: "172.22.132.13"},
+		},
+	}
+
+	status := newStatus(claim, ips)
+	assert.Equal(t, v1.ConditionFalse, status.Conditions[0].Status)
+	assert.Equal(t, "AddressMismatch", status.Conditions[0].Reason)
+
+	ips[0].Status = blendedv1.IPStatus{}
+	status = newStatus(claim, ips)
+	assert.Equal(t, "Pending", status.Conditions[0].Reason)
+}
+
+func TestUpdateKeepsConcurrentChanges(t *testing.T) {
+	controller := newTestController(k8sutil.NewMemoryAllocator())
+	claim := newClaim(1)
+
+	// The claim was changed by the user after it was listed.
+	changed := claim.DeepCopy()
+	changed.Annotations = map[string]string{"note": "changed"}
+	u, err := ToUnstructured(changed)
+	assert.Nil(t, err)
+	_, err = controller.dynamicset.Resource(Resource).Namespace(claim.Namespace).Create(u, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	assert.Nil(t, controller.update(claim, func(latest *IPClaim) {
+		latest.Finalizers = append(latest.Finalizers, constants.Finalizer)
+	}))
+
+	u, err = controller.dynamicset.Resource(Resource).Namespace(claim.Namespace).Get(claim.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{constants.Finalizer}, u.GetFinalizers())
+	assert.Equal(t, "changed", u.GetAnnotations()["note"])
+}
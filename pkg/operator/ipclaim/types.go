@@ -0,0 +1,99 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ipclaim
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Resource is the resource of IPClaims
+var Resource = schema.GroupVersionResource{Group: "inwinstack.com", Version: "v1", Resource: "ipclaims"}
+
+// ConditionReady represents whether all addresses of a claim were allocated.
+const ConditionReady = "Ready"
+
+// IPClaim represents a declarative allocation of addresses, which are not bound to a Namespace or a Service.
+type IPClaim struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IPClaimSpec   `json:"spec"`
+	Status IPClaimStatus `json:"status,omitempty"`
+}
+
+// IPClaimSpec is the spec of an IPClaim. Either the number of addresses or the specific addresses are claimed.
+type IPClaimSpec struct {
+	Pool         string   `json:"pool"`
+	Count        int      `json:"count,omitempty"`
+	Addresses    []string `json:"addresses,omitempty"`
+	RetainPolicy string   `json:"retainPolicy,omitempty"`
+}
+
+// IPClaimStatus is the status of an IPClaim
+type IPClaimStatus struct {
+	Addresses  []string           `json:"addresses,omitempty"`
+	Conditions []IPClaimCondition `json:"conditions,omitempty"`
+}
+
+// IPClaimCondition represents a condition of an IPClaim
+type IPClaimCondition struct {
+	Type               string             `json:"type"`
+	Status             v1.ConditionStatus `json:"status"`
+	Reason             string             `json:"reason,omitempty"`
+	Message            string             `json:"message,omitempty"`
+	LastTransitionTime metav1.Time        `json:"lastTransitionTime,omitempty"`
+}
+
+// DeepCopy copies the IPClaim
+func (c *IPClaim) DeepCopy() *IPClaim {
+	out := &IPClaim{TypeMeta: c.TypeMeta, Spec: c.Spec}
+	c.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec.Addresses = append([]string(nil), c.Spec.Addresses...)
+	out.Status.Addresses = append([]string(nil), c.Status.Addresses...)
+	out.Status.Conditions = append([]IPClaimCondition(nil), c.Status.Conditions...)
+	return out
+}
+
+// Number returns the number of claimed addresses
+func (c *IPClaim) Number() int {
+	if len(c.Spec.Addresses) > 0 {
+		return len(c.Spec.Addresses)
+	}
+	return c.Spec.Count
+}
+
+// FromUnstructured converts an unstructured object to an IPClaim
+func FromUnstructured(u *unstructured.Unstructured) (*IPClaim, error) {
+	claim := &IPClaim{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, claim); err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+// ToUnstructured converts an IPClaim to an unstructured object
+func ToUnstructured(claim *IPClaim) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(claim)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
@@ -27,60 +27,97 @@ import (
 
 	"github.com/golang/glog"
 	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
-	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
 	"github.com/inwinstack/ip-assigner/pkg/config"
 	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/health"
 	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
 	"github.com/thoas/go-funk"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/uuid"
-	"k8s.io/apimachinery/pkg/util/wait"
 	informerv1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/util/workqueue"
+	"k8s.io/client-go/tools/record"
 )
 
+// migrationCheckPeriod is the period of checking whether the IPs of a new pool are active.
+const migrationCheckPeriod = time.Second * 10
+
+// parkCheckPeriod is the period of checking the pools of the parked Namespaces.
+const parkCheckPeriod = time.Minute
+
 // Controller represents the controller of namespace
 type Controller struct {
 	cfg *config.Config
 
-	clientset  kubernetes.Interface
-	blendedset blended.Interface
-	lister     listerv1.NamespaceLister
-	synced     cache.InformerSynced
-	queue      workqueue.RateLimitingInterface
+	clientset kubernetes.Interface
+	allocator k8sutil.Allocator
+	sink      audit.Sink
+	lister    listerv1.NamespaceLister
+	synced    cache.InformerSynced
+	queue     *k8sutil.PriorityQueue
+	workers   *k8sutil.Workers
+	tracker   *health.Tracker
+	latency   *k8sutil.Latency
+	parker    *k8sutil.Parker
+	hashes    *k8sutil.Hashes
+	recorder  record.EventRecorder
+	keys      *constants.Keys
+}
+
+// statusKeys returns the annotations which the controller writes, updating only them does not need a reconcile.
+func (c *Controller) statusKeys() []string {
+	return []string{
+		c.keys.IPsKey,
+		c.keys.LatestIPKey,
+		c.keys.AssignedPoolKey,
+		c.keys.LatestPoolKey,
+		c.keys.DrainUntilKey,
+		c.keys.ReconcileFailedKey,
+	}
 }
 
 // NewController creates an instance of the namespace controller
 func NewController(
 	cfg *config.Config,
 	clientset kubernetes.Interface,
-	blendedset blended.Interface,
+	allocator k8sutil.Allocator,
+	sink audit.Sink,
 	informer informerv1.NamespaceInformer) *Controller {
 	controller := &Controller{
-		cfg:        cfg,
-		clientset:  clientset,
-		blendedset: blendedset,
-		lister:     informer.Lister(),
-		synced:     informer.Informer().HasSynced,
-		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Namespaces"),
+		cfg:       cfg,
+		clientset: clientset,
+		allocator: allocator,
+		sink:      sink,
+		lister:    informer.Lister(),
+		synced:    informer.Informer().HasSynced,
+		queue:     k8sutil.NewPriorityQueue(k8sutil.NewRateLimiter(cfg.Controller(config.NamespaceController)), "Namespaces"),
+		latency:   k8sutil.NewFirstAllocation("Namespaces"),
+		parker:    k8sutil.NewParker(allocator),
+		hashes:    k8sutil.NewHashes(),
+		recorder:  k8sutil.NewEventRecorder(clientset),
+		keys:      cfg.Keys(),
 	}
+	controller.tracker = health.NewTracker("Namespaces", controller.queue.Len, controller.synced)
+	controller.workers = k8sutil.NewWorkers(controller.tracker.Name(), controller.tracker)
 	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.enqueue,
+		AddFunc: func(obj interface{}) {
+			// The Namespaces without IPs go ahead of the ones listed again after a restart.
+			controller.enqueue(obj, obj.(*v1.Namespace).Annotations[controller.keys.IPsKey] == "")
+		},
 		UpdateFunc: func(old, new interface{}) {
 			oo := old.(*v1.Namespace)
 			no := new.(*v1.Namespace)
-			ooPool := oo.Annotations[constants.PrivatePoolKey]
-			noPool := no.Annotations[constants.PrivatePoolKey]
-			if ooPool != "" && noPool != "" && ooPool != noPool {
-				no.Annotations[constants.LatestPoolKey] = ooPool
+			if k8sutil.OnlyChanged(oo, no, controller.statusKeys()...) {
+				return
 			}
-			controller.enqueue(no)
+			// The changes go ahead of the resyncs.
+			controller.enqueue(no, oo.ResourceVersion != no.ResourceVersion)
 		},
 	})
 	return controller
@@ -94,8 +131,9 @@ func (c *Controller) Run(ctx context.Context, threadiness int) error {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, ctx.Done())
+		c.workers.Go(ctx, c.runWorker, time.Second)
 	}
+	c.workers.Go(ctx, c.retryParked, parkCheckPeriod)
 	return nil
 }
 
@@ -105,6 +143,16 @@ func (c *Controller) Stop() {
 	c.queue.ShutDown()
 }
 
+// Wait waits for the in-flight reconciles to finish, the keys which are still reconciled after the timeout are abandoned.
+func (c *Controller) Wait(timeout time.Duration) {
+	c.workers.Wait(timeout)
+}
+
+// Tracker returns the tracker of the Namespace controller
+func (c *Controller) Tracker() *health.Tracker {
+	return c.tracker
+}
+
 func (c *Controller) runWorker() {
 	defer utilruntime.HandleCrash()
 	for c.processNextWorkItem() {
@@ -117,6 +165,12 @@ func (c *Controller) processNextWorkItem() bool {
 		return false
 	}
 
+	// The queued keys are dropped once the controller is stopped.
+	if c.queue.ShuttingDown() {
+		c.queue.Done(obj)
+		return false
+	}
+
 	err := func(obj interface{}) error {
 		defer c.queue.Done(obj)
 		key, ok := obj.(string)
@@ -126,9 +180,11 @@ func (c *Controller) processNextWorkItem() bool {
 			return nil
 		}
 
-		if err := c.reconcile(key); err != nil {
-			c.queue.AddRateLimited(key)
-			return fmt.Errorf("Namespace controller error syncing '%s': %s, requeuing", key, err.Error())
+		c.tracker.Start(key)
+		err := c.reconcile(key)
+		c.tracker.Finish(key, err)
+		if err != nil {
+			return c.retry(key, err)
 		}
 
 		c.queue.Forget(obj)
@@ -143,15 +199,100 @@ func (c *Controller) processNextWorkItem() bool {
 	return true
 }
 
-func (c *Controller) enqueue(obj interface{}) {
+// enqueue queues the key of the object, the keys with priority are reconciled first.
+func (c *Controller) enqueue(obj interface{}, priority bool) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	// The objects of other assigner classes are handled by other instances.
+	if !c.cfg.Handles(m.GetAnnotations()) {
+		return
+	}
+
 	key, err := cache.MetaNamespaceKeyFunc(obj)
 	if err != nil {
 		utilruntime.HandleError(err)
 		return
 	}
+
+	if c.parker.Parked(key, m.GetResourceVersion()) {
+		return
+	}
+	if priority {
+		c.queue.AddPriority(key)
+		return
+	}
 	c.queue.Add(key)
 }
 
+// retry requeues the failed key, or parks it on a permanent error or after the max retries. Transient errors,
+// e.g. timeouts, conflicts or an unreachable IPAM, are retried with backoff until they go away.
+func (c *Controller) retry(key string, reconcileErr error) error {
+	permanent := k8sutil.IsPermanent(reconcileErr)
+	if !permanent && (c.cfg.MaxRetries <= 0 || c.queue.NumRequeues(key) < c.cfg.MaxRetries) {
+		c.queue.AddRateLimited(key)
+		return fmt.Errorf("Namespace controller error syncing '%s': %s, requeuing", key, reconcileErr.Error())
+	}
+
+	if err := c.park(key, reconcileErr); err != nil {
+		if errors.IsNotFound(err) {
+			// The Namespace was deleted meanwhile, so there is nothing left to retry.
+			c.forget(key)
+			return nil
+		}
+		c.queue.AddRateLimited(key)
+		return fmt.Errorf("Namespace controller failed to park '%s': %s, requeuing", key, err.Error())
+	}
+	c.queue.Forget(key)
+	return fmt.Errorf("Namespace controller error syncing '%s': %s, parked", key, reconcileErr.Error())
+}
+
+// forget drops the state of the key, once its object is gone.
+func (c *Controller) forget(key string) {
+	c.queue.Forget(key)
+	c.parker.Forget(key)
+	c.hashes.Delete(key)
+}
+
+// park records the error on the Namespace, and parks the key until the Namespace or its pool changes.
+func (c *Controller) park(key string, reconcileErr error) error {
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.lister.Get(name)
+	if err != nil {
+		return err
+	}
+
+	objCopy := obj.DeepCopy()
+	if objCopy.Annotations == nil {
+		objCopy.Annotations = map[string]string{}
+	}
+	objCopy.Annotations[c.keys.ReconcileFailedKey] = reconcileErr.Error()
+	updated, err := k8sutil.PatchNamespace(c.clientset, obj, objCopy)
+	if err != nil {
+		return err
+	}
+
+	pool := c.poolName(updated)
+	c.parker.Park(key, updated.ResourceVersion, pool)
+	c.recorder.Eventf(updated, v1.EventTypeWarning, "ReconcileFailed",
+		"Giving up until the Namespace or pool '%s' changes: %s", pool, reconcileErr.Error())
+	return nil
+}
+
+// retryParked retries the parked keys whose pool was changed.
+func (c *Controller) retryParked() {
+	for _, key := range c.parker.PoolChanged() {
+		c.queue.Add(key)
+	}
+}
+
 func (c *Controller) reconcile(key string) error {
 	_, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -162,126 +303,342 @@ func (c *Controller) reconcile(key string) error {
 	ns, err := c.lister.Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			c.hashes.Delete(key)
 			utilruntime.HandleError(fmt.Errorf("namespace '%s' in work queue no longer exists", key))
 			return err
 		}
 		return err
 	}
 
-	c.makeDefaultPool(ns)
-	pool, err := k8sutil.GetPool(c.blendedset, ns.ObjectMeta, constants.PrivatePoolKey)
+	nsCopy := ns.DeepCopy()
+	if nsCopy.Annotations == nil {
+		nsCopy.Annotations = map[string]string{}
+	}
+	delete(nsCopy.Annotations, c.keys.ReconcileFailedKey)
+	pool, err := c.allocator.Pool(c.poolName(nsCopy))
 	if err != nil {
+		if errors.IsNotFound(err) {
+			return k8sutil.Permanent(err)
+		}
 		return err
 	}
 
-	if funk.ContainsString(pool.Spec.IgnoreNamespaces, ns.Name) || !pool.Spec.AssignToNamespace {
+	if funk.ContainsString(pool.Spec.IgnoreNamespaces, nsCopy.Name) || !pool.Spec.AssignToNamespace {
+		return nil
+	}
+
+	// Nothing changed since the last reconcile.
+	ips, err := c.listIPs(nsCopy)
+	if err != nil {
+		return err
+	}
+	if c.hashes.Applied(key, inputHash(ns, pool, ips)) {
+		glog.V(4).Infof("Namespace controller skipped '%s', nothing changed", key)
 		return nil
 	}
 
-	if _, ok := ns.Annotations[constants.LatestPoolKey]; ok {
-		if err := c.releaseIPsOfLatestPool(ns); err != nil {
+	latest := c.migratingFrom(nsCopy, pool.Name)
+	if err := c.releaseStaleIPs(nsCopy, pool.Name, latest); err != nil {
+		return err
+	}
+
+	number, err := numberOfIPs(c.keys, nsCopy)
+	if err != nil {
+		return err
+	}
+	if err := c.syncIPs(nsCopy, pool.Name, number); err != nil {
+		return err
+	}
+
+	var requeueAfter time.Duration
+	if latest != "" {
+		if requeueAfter, err = c.migrate(nsCopy, pool.Name, latest, number); err != nil {
 			return err
 		}
 	}
 
-	if err := c.syncIPs(ns, pool.Name); err != nil {
+	updated, err := c.updateStatus(ns, nsCopy, pool.Name, number)
+	if err != nil {
 		return err
 	}
-	return c.updateStatus(ns, pool.Name)
+	if ns.Annotations[c.keys.IPsKey] == "" && updated.Annotations[c.keys.IPsKey] != "" {
+		c.latency.ObserveSince(ns.CreationTimestamp.Time)
+	}
+	if requeueAfter > 0 {
+		c.hashes.Delete(key)
+		c.queue.AddAfter(key, requeueAfter)
+		return nil
+	}
+
+	// Record the state after the reconcile, so that the next one is skipped if nothing changes.
+	if ips, err = c.listIPs(nsCopy); err == nil {
+		c.hashes.Set(key, inputHash(updated, pool, ips))
+	}
+	return nil
 }
 
-func (c *Controller) makeDefaultPool(ns *v1.Namespace) {
-	if ns.Annotations == nil {
-		ns.Annotations = map[string]string{}
+// inputHash returns the hash of the inputs of a reconcile.
+func inputHash(ns *v1.Namespace, pool *blendedv1.Pool, ips *blendedv1.IPList) string {
+	return k8sutil.Hash(ns.Annotations, pool.Spec, k8sutil.IPStates(ips))
+}
+
+// migratingFrom returns the pool which the namespace is migrating from, and persists it
+// in the annotations when the pool was changed. It is empty if there is no migration.
+func (c *Controller) migratingFrom(ns *v1.Namespace, poolName string) string {
+	if latest := ns.Annotations[c.keys.LatestPoolKey]; latest != "" && latest != poolName {
+		return latest
+	}
+
+	// The pool was changed back during a migration.
+	delete(ns.Annotations, c.keys.LatestPoolKey)
+	delete(ns.Annotations, c.keys.DrainUntilKey)
+
+	assigned := ns.Annotations[c.keys.AssignedPoolKey]
+	if assigned == "" || assigned == poolName {
+		ns.Annotations[c.keys.AssignedPoolKey] = poolName
+		return ""
 	}
 
-	if ns.Annotations[constants.NumberOfIPKey] == "" {
-		ns.Annotations[constants.NumberOfIPKey] = strconv.Itoa(constants.DefaultNumberOfIP)
+	ns.Annotations[c.keys.LatestPoolKey] = assigned
+	audit.Record(c.sink, c.event(ns, audit.ActionPoolSwitch, poolName, nil, fmt.Sprintf("migrating from pool '%s'", assigned)))
+	glog.V(2).Infof("Namespace controller started migrating '%s' from pool '%s' to '%s'.", ns.Name, assigned, poolName)
+	return assigned
+}
+
+// migrate releases the IPs of the latest pool once all IPs of the pool are active and the
+// drain period has passed. It returns how long to wait for the next step.
+func (c *Controller) migrate(ns *v1.Namespace, poolName, latest string, number int) (time.Duration, error) {
+	ips, err := c.listIPs(ns)
+	if err != nil {
+		return 0, err
 	}
 
-	if _, err := strconv.Atoi(ns.Annotations[constants.NumberOfIPKey]); err != nil {
-		ns.Annotations[constants.NumberOfIPKey] = strconv.Itoa(constants.DefaultNumberOfIP)
+	k8sutil.FilterIPsByPool(ips, poolName)
+	active := 0
+	for _, ip := range ips.Items {
+		if ip.ObjectMeta.DeletionTimestamp.IsZero() && ip.Status.Phase != blendedv1.IPFailed && net.ParseIP(ip.Status.Address) != nil {
+			active++
+		}
+	}
+	if active < number {
+		glog.V(2).Infof("Namespace controller is waiting for %d IPs of pool '%s' in '%s'.", number-active, poolName, ns.Name)
+		return migrationCheckPeriod, nil
 	}
 
-	if ns.Annotations[constants.PrivatePoolKey] == "" {
-		ns.Annotations[constants.PrivatePoolKey] = c.cfg.PrivatePool
+	until, err := time.Parse(time.RFC3339, ns.Annotations[c.keys.DrainUntilKey])
+	if err != nil {
+		until = time.Now().Add(c.cfg.MigrationDrainPeriod)
+		ns.Annotations[c.keys.DrainUntilKey] = until.UTC().Format(time.RFC3339)
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait, nil
 	}
+
+	old, err := c.listIPs(ns)
+	if err != nil {
+		return 0, err
+	}
+	k8sutil.FilterIPsByPool(old, latest)
+	kept, err := c.release(ns, old.Items, "pool migrated")
+	if err != nil {
+		return 0, err
+	}
+	if kept > 0 {
+		// The protected IPs keep the latest pool, the migration finishes once they are unprotected.
+		glog.V(2).Infof("Namespace controller is keeping %d protected IPs of pool '%s' in '%s'.", kept, latest, ns.Name)
+		return 0, nil
+	}
+
+	delete(ns.Annotations, c.keys.LatestPoolKey)
+	delete(ns.Annotations, c.keys.DrainUntilKey)
+	ns.Annotations[c.keys.AssignedPoolKey] = poolName
+	glog.V(2).Infof("Namespace controller finished migrating '%s' from pool '%s' to '%s'.", ns.Name, latest, poolName)
+	return 0, nil
 }
 
-func (c *Controller) syncIPs(ns *v1.Namespace, poolName string) error {
-	ips, err := c.blendedset.InwinstackV1().IPs(ns.Name).List(metav1.ListOptions{})
+// listIPs returns the IPs owned by the Namespace, and the IPs of the namespace without owner labels, which were
+// allocated before ip-assigner labelled them. The IPs of IPClaims and Services in the namespace are left out.
+func (c *Controller) listIPs(ns *v1.Namespace) (*blendedv1.IPList, error) {
+	owned, err := c.allocator.ListByOwner(k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: ns.Name, Name: ns.Name})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	number, err := strconv.Atoi(ns.Annotations[constants.NumberOfIPKey])
+	all, err := c.allocator.List(ns.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range all.Items {
+		if ip.Labels[c.keys.OwnerKindLabel] == "" {
+			owned.Items = append(owned.Items, ip)
+		}
+	}
+	return owned, nil
+}
+
+// ownsIP reports whether the IP belongs to the Namespace, by its owner labels or by having none.
+func ownsIP(keys *constants.Keys, ns *v1.Namespace, ip *blendedv1.IP) bool {
+	switch ip.Labels[keys.OwnerKindLabel] {
+	case "":
+		return true
+	case constants.OwnerKindNamespace:
+		return ip.Labels[keys.OwnerNameLabel] == ns.Name
+	}
+	return false
+}
+
+// releaseStaleIPs releases the IPs of the namespace which are neither in the pool nor in the
+// latest pool, e.g. when the pool was changed again during a migration.
+func (c *Controller) releaseStaleIPs(ns *v1.Namespace, pools ...string) error {
+	owned, err := c.allocator.ListByOwner(k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: ns.Name, Name: ns.Name})
 	if err != nil {
 		return err
 	}
 
-	k8sutil.FilterIPsByPool(ips, poolName)
-	sort.Slice(ips.Items, func(i, j int) bool {
-		return ips.Items[i].Status.LastUpdateTime.Time.Before(ips.Items[j].Status.LastUpdateTime.Time)
-	})
-	return c.createOrDeleteIPs(ns, ips, number, poolName)
+	var stale []blendedv1.IP
+	for _, ip := range owned.Items {
+		if !funk.ContainsString(pools, ip.Spec.PoolName) {
+			stale = append(stale, ip)
+		}
+	}
+	_, err = c.release(ns, stale, "pool changed during migration")
+	return err
+}
+
+// poolName returns the pool of the Namespace. The defaults are not written to the annotations, which are left
+// to the user.
+func (c *Controller) poolName(ns *v1.Namespace) string {
+	if pool := ns.Annotations[c.keys.PrivatePoolKey]; pool != "" {
+		return pool
+	}
+	return c.cfg.PrivatePool
 }
 
-func (c *Controller) releaseIPsOfLatestPool(ns *v1.Namespace) error {
-	ips, err := c.blendedset.InwinstackV1().IPs(ns.Name).List(metav1.ListOptions{})
+// numberOfIPs returns the number of IPs the Namespace asks for. An invalid number is a permanent error, the
+// Namespace is parked until the user fixes it.
+func numberOfIPs(keys *constants.Keys, ns *v1.Namespace) (int, error) {
+	value := ns.Annotations[keys.NumberOfIPKey]
+	if value == "" {
+		return constants.DefaultNumberOfIP, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, k8sutil.Permanent(fmt.Errorf("invalid %s annotation '%s'", keys.NumberOfIPKey, value))
+	}
+	return number, nil
+}
+
+func (c *Controller) syncIPs(ns *v1.Namespace, poolName string, number int) error {
+	ips, err := c.listIPs(ns)
 	if err != nil {
 		return err
 	}
 
-	poolName := ns.Annotations[constants.LatestPoolKey]
 	k8sutil.FilterIPsByPool(ips, poolName)
-	if err := c.createOrDeleteIPs(ns, ips, 0, poolName); err != nil {
-		return err
+	sort.Slice(ips.Items, func(i, j int) bool {
+		return ips.Items[i].Status.LastUpdateTime.Time.Before(ips.Items[j].Status.LastUpdateTime.Time)
+	})
+	if number < len(ips.Items) {
+		return c.scaleDown(ns, ips, number, poolName)
 	}
-	delete(ns.Annotations, constants.LatestPoolKey)
-	return nil
+	return c.createOrDeleteIPs(ns, ips, number, poolName, "number of IPs changed")
 }
 
-func (c *Controller) createOrDeleteIPs(ns *v1.Namespace, ips *blendedv1.IPList, number int, poolName string) error {
+// createOrDeleteIPs creates or releases IPs until the namespace has the number of IPs in the pool. The IPs are
+// created in the free slots of the listed IPs. A slot which already exists but was not listed yet, e.g. after a
+// previous create, counts as filled if the Namespace owns it, otherwise it is skipped for the next free slot. A
+// failure does not stop the other creates and releases, the errors are returned together.
+func (c *Controller) createOrDeleteIPs(ns *v1.Namespace, ips *blendedv1.IPList, number int, poolName, reason string) error {
+	var errs []error
+
 	// Create IPs if the number is more than the length of ips.Items.
-	for i := 0; i < (number - len(ips.Items)); i++ {
-		name := fmt.Sprintf("%s", uuid.NewUUID())
-		if _, err := k8sutil.NewIP(c.blendedset, name, ns.Name, poolName); err != nil {
-			return err
+	used := map[string]bool{}
+	for _, ip := range ips.Items {
+		used[ip.Name] = true
+	}
+	for slot, filled := 0, len(ips.Items); filled < number; slot++ {
+		name := k8sutil.NamespaceIPName(ns.Name, poolName, slot)
+		if used[c.allocator.Name(name)] {
+			continue
 		}
+
+		req := &k8sutil.Request{
+			Name:  name,
+			Pool:  poolName,
+			Owner: k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: ns.Name, Name: ns.Name},
+		}
+		ip, err := c.allocator.Allocate(req)
+		if errors.IsAlreadyExists(err) {
+			existing, err := c.allocator.Get(ns.Name, name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to get IP '%s': %s", c.allocator.Name(name), err.Error()))
+				filled++
+				continue
+			}
+			if ownsIP(c.keys, ns, existing) {
+				filled++
+			}
+			continue
+		}
+		filled++
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to create IP '%s': %s", name, err.Error()))
+			continue
+		}
+		audit.Record(c.sink, c.event(ns, audit.ActionAllocate, poolName, ip, reason))
 	}
 
 	// Delete IPs if the number is less than the length of ips.Items.
-	for i := 0; i < (len(ips.Items) - number); i++ {
-		ip := ips.Items[len(ips.Items)-(1+i)]
-		if err := c.blendedset.InwinstackV1().IPs(ns.Name).Delete(ip.Name, nil); err != nil {
-			return err
+	if number < len(ips.Items) {
+		if _, err := c.release(ns, ips.Items[number:], reason); err != nil {
+			errs = append(errs, err)
 		}
 	}
-	return nil
+	return utilerrors.NewAggregate(errs)
 }
 
-func (c *Controller) updateStatus(ns *v1.Namespace, poolName string) error {
-	nsCopy := ns.DeepCopy()
-	ips, err := c.blendedset.InwinstackV1().IPs(nsCopy.Name).List(metav1.ListOptions{})
-	if err != nil {
-		return err
+func (c *Controller) event(ns *v1.Namespace, action, poolName string, ip *blendedv1.IP, reason string) *audit.Event {
+	e := &audit.Event{
+		Action:    action,
+		OwnerKind: constants.OwnerKindNamespace,
+		Namespace: ns.Name,
+		Name:      ns.Name,
+		Pool:      poolName,
+		Reason:    reason,
+	}
+	if ip != nil {
+		e.IP = ip.Name
+		e.Address = ip.Status.Address
 	}
+	return e
+}
 
-	number, err := strconv.Atoi(nsCopy.Annotations[constants.NumberOfIPKey])
+// updateStatus publishes the IPs of the pool, and the IPs of the latest pool during a migration. The changes
+// from the Namespace to its copy are patched, and the updated Namespace is returned.
+func (c *Controller) updateStatus(ns, nsCopy *v1.Namespace, poolName string, number int) (*v1.Namespace, error) {
+	ips, err := c.listIPs(nsCopy)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	switch {
 	case number == 0:
-		delete(nsCopy.Annotations, constants.LatestIPKey)
-		delete(nsCopy.Annotations, constants.IPsKey)
+		delete(nsCopy.Annotations, c.keys.LatestIPKey)
+		delete(nsCopy.Annotations, c.keys.IPsKey)
 	case number > 0:
+		migrating := nsCopy.Annotations[c.keys.LatestPoolKey] != ""
+		latest := ips.DeepCopy()
+		k8sutil.FilterIPsByPool(latest, nsCopy.Annotations[c.keys.LatestPoolKey])
 		k8sutil.FilterIPsByPool(ips, poolName)
+		if migrating {
+			ips.Items = append(latest.Items, ips.Items...)
+		}
 		sort.Slice(ips.Items, func(i, j int) bool {
 			return ips.Items[i].Status.LastUpdateTime.Time.Before(ips.Items[j].Status.LastUpdateTime.Time)
 		})
 
+		assigned := strings.Split(nsCopy.Annotations[c.keys.IPsKey], ",")
 		var addrs []string
 		for _, ip := range ips.Items {
 			if ip.ObjectMeta.DeletionTimestamp.IsZero() {
@@ -291,20 +648,24 @@ func (c *Controller) updateStatus(ns *v1.Namespace, poolName string) error {
 
 				addr := net.ParseIP(ip.Status.Address)
 				if addr == nil {
-					return fmt.Errorf("failed to get IP address")
+					// The IPs of the latest pool are still published while waiting for the new ones.
+					if migrating {
+						continue
+					}
+					return nil, fmt.Errorf("failed to get IP address")
 				}
 				addrs = append(addrs, addr.String())
+				if !funk.ContainsString(assigned, addr.String()) {
+					audit.Record(c.sink, c.event(nsCopy, audit.ActionAssign, ip.Spec.PoolName, &ip, "address published"))
+				}
 			}
 		}
 
-		nsCopy.Annotations[constants.IPsKey] = strings.Join(addrs, ",")
+		nsCopy.Annotations[c.keys.IPsKey] = strings.Join(addrs, ",")
 		if len(addrs) > 0 {
-			nsCopy.Annotations[constants.LatestIPKey] = addrs[len(addrs)-1]
+			nsCopy.Annotations[c.keys.LatestIPKey] = addrs[len(addrs)-1]
 		}
 	}
 
-	if _, err := c.clientset.CoreV1().Namespaces().Update(nsCopy); err != nil {
-		return err
-	}
-	return nil
+	return k8sutil.PatchNamespace(c.clientset, ns, nsCopy)
 }
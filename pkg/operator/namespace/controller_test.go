@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -27,7 +28,9 @@ import (
 
 	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
 	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
 	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -38,6 +41,30 @@ import (
 
 const timeout = time.Second * 3
 
+type recordingSink struct {
+	sync.Mutex
+	events []audit.Event
+}
+
+func (s *recordingSink) Record(e *audit.Event) error {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, *e)
+	return nil
+}
+
+func (s *recordingSink) count(action string) int {
+	s.Lock()
+	defer s.Unlock()
+	n := 0
+	for _, e := range s.events {
+		if e.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
 func TestNamespaceController(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cfg := &config.Config{
@@ -49,7 +76,8 @@ func TestNamespaceController(t *testing.T) {
 	blendedset := blendedfake.NewSimpleClientset()
 	informer := informers.NewSharedInformerFactory(clientset, 0)
 
-	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Namespaces())
+	allocator := k8sutil.NewBlendedAllocator(blendedset, constants.DefaultKeys)
+	controller := NewController(cfg, clientset, allocator, nil, informer.Core().V1().Namespaces())
 	go informer.Start(ctx.Done())
 	assert.Nil(t, controller.Run(ctx, cfg.Threads))
 
@@ -130,3 +158,328 @@ func TestNamespaceController(t *testing.T) {
 	cancel()
 	controller.Stop()
 }
+
+func TestNamespaceControllerWithMemoryAllocator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:     2,
+		PrivatePool: "default",
+	}
+
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: cfg.PrivatePool,
+		},
+		Spec: blendedv1.PoolSpec{
+			Addresses:         []string{"172.22.132.10-172.22.132.15"},
+			AssignToNamespace: true,
+		},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	sink := &recordingSink{}
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+
+	controller := NewController(cfg, clientset, allocator, sink, informer.Core().V1().Namespaces())
+	go informer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{constants.NumberOfIPKey: "2"},
+		},
+	}
+	_, err := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, err)
+
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		gns, err := clientset.CoreV1().Namespaces().Get(ns.Name, metav1.GetOptions{})
+		assert.Nil(t, err)
+		if gns.Annotations[constants.IPsKey] == "172.22.132.10,172.22.132.11" {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the private IPs.")
+	assert.Equal(t, 2, sink.count(audit.ActionAllocate))
+
+	cancel()
+	controller.Stop()
+}
+
+func TestNamespacePoolMigration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:              2,
+		PrivatePool:          "old",
+		MigrationDrainPeriod: time.Second,
+	}
+
+	newPool := func(name, addresses string) *blendedv1.Pool {
+		return &blendedv1.Pool{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       blendedv1.PoolSpec{Addresses: []string{addresses}, AssignToNamespace: true},
+		}
+	}
+
+	clientset := fake.NewSimpleClientset()
+	allocator := k8sutil.NewMemoryAllocator(newPool("old", "172.22.132.10-172.22.132.15"), newPool("new", "172.22.133.10-172.22.133.15"))
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+
+	controller := NewController(cfg, clientset, allocator, nil, informer.Core().V1().Namespaces())
+	go informer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	_, err := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, err)
+
+	waitFor := func(ips string, msg string) *corev1.Namespace {
+		for start := time.Now(); time.Since(start) < timeout; time.Sleep(time.Millisecond * 10) {
+			gns, err := clientset.CoreV1().Namespaces().Get(ns.Name, metav1.GetOptions{})
+			assert.Nil(t, err)
+			if gns.Annotations[constants.IPsKey] == ips {
+				return gns
+			}
+		}
+		t.Fatal(msg)
+		return nil
+	}
+
+	gns := waitFor("172.22.132.10", "cannot get the IP of the old pool.")
+	assert.Equal(t, "old", gns.Annotations[constants.AssignedPoolKey])
+
+	// Both pools are published during the drain period, then the old IP is released.
+	gns.Annotations[constants.PrivatePoolKey] = "new"
+	_, err = clientset.CoreV1().Namespaces().Update(gns)
+	assert.Nil(t, err)
+
+	gns = waitFor("172.22.132.10,172.22.133.10", "cannot get the IPs of both pools.")
+	assert.Equal(t, "old", gns.Annotations[constants.LatestPoolKey])
+
+	gns = waitFor("172.22.133.10", "failed to release the IP of the old pool.")
+	assert.Equal(t, "new", gns.Annotations[constants.AssignedPoolKey])
+	assert.Empty(t, gns.Annotations[constants.LatestPoolKey])
+	assert.Empty(t, gns.Annotations[constants.DrainUntilKey])
+
+	ips, err := allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 1)
+
+	cancel()
+	controller.Stop()
+}
+
+func TestNamespaceParking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		Threads:     1,
+		PrivatePool: "missing",
+		MaxRetries:  2,
+	}
+
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(cfg, clientset, k8sutil.NewMemoryAllocator(), nil, informer.Core().V1().Namespaces())
+	go informer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx, cfg.Threads))
+
+	_, err := clientset.CoreV1().Namespaces().Create(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}})
+	assert.Nil(t, err)
+
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; time.Sleep(time.Millisecond * 10) {
+		gns, err := clientset.CoreV1().Namespaces().Get("test", metav1.GetOptions{})
+		assert.Nil(t, err)
+		if gns.Annotations[constants.ReconcileFailedKey] != "" {
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "the Namespace was not parked.")
+	assert.Equal(t, 1, controller.parker.Len())
+
+	cancel()
+	controller.Stop()
+}
+
+func TestCreateOrDeleteIPs(t *testing.T) {
+	cfg := &config.Config{PrivatePool: "default"}
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: cfg.PrivatePool},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.15"}, AssignToNamespace: true},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	sink := &recordingSink{}
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(cfg, clientset, allocator, sink, informer.Core().V1().Namespaces())
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	assert.Nil(t, controller.createOrDeleteIPs(ns, &blendedv1.IPList{}, 2, pool.Name, "test"))
+
+	// A retry with a stale list counts the owned slots as filled instead of allocating again.
+	assert.Nil(t, controller.createOrDeleteIPs(ns, &blendedv1.IPList{}, 2, pool.Name, "test"))
+
+	ips, err := allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 2)
+	assert.Equal(t, "test-default-0", ips.Items[0].Name)
+	assert.Equal(t, "test-default-1", ips.Items[1].Name)
+	assert.Equal(t, 2, sink.count(audit.ActionAllocate))
+
+	// An IP which was already released does not stop the others.
+	missing := ips.DeepCopy()
+	missing.Items = append([]blendedv1.IP{{ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: ns.Name}}}, missing.Items...)
+	assert.Nil(t, controller.createOrDeleteIPs(ns, missing, 0, pool.Name, "test"))
+
+	ips, err = allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Empty(t, ips.Items)
+
+	// A slot taken by another owner is skipped for the next free slot.
+	_, err = allocator.Allocate(&k8sutil.Request{
+		Name:  "test-default-0",
+		Pool:  pool.Name,
+		Owner: k8sutil.Owner{Kind: constants.OwnerKindIPClaim, Namespace: ns.Name, Name: "vm"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, controller.createOrDeleteIPs(ns, &blendedv1.IPList{}, 1, pool.Name, "test"))
+
+	ips, err = allocator.ListByOwner(k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: ns.Name, Name: ns.Name})
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 1)
+	assert.Equal(t, "test-default-1", ips.Items[0].Name)
+}
+
+func TestCreateOrDeleteIPsWithClusterAllocator(t *testing.T) {
+	cfg := &config.Config{PrivatePool: "default"}
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: cfg.PrivatePool},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.15"}, AssignToNamespace: true},
+	}
+
+	clientset := fake.NewSimpleClientset()
+	allocator := k8sutil.NewClusterAllocator(k8sutil.NewMemoryAllocator(pool), "east", constants.DefaultKeys)
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(cfg, clientset, allocator, nil, informer.Core().V1().Namespaces())
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}}
+	assert.Nil(t, controller.createOrDeleteIPs(ns, &blendedv1.IPList{}, 2, pool.Name, "test"))
+
+	// Scaling up with the prefixed names listed creates the missing slots.
+	ips, err := allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Nil(t, controller.createOrDeleteIPs(ns, ips, 4, pool.Name, "test"))
+
+	ips, err = allocator.List(ns.Name)
+	assert.Nil(t, err)
+	var names []string
+	for _, ip := range ips.Items {
+		names = append(names, ip.Name)
+	}
+	assert.Equal(t, []string{"east-test-default-0", "east-test-default-1", "east-test-default-2", "east-test-default-3"}, names)
+}
+
+func TestNamespaceParkingDeleted(t *testing.T) {
+	cfg := &config.Config{PrivatePool: "default", MaxRetries: 1}
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(cfg, clientset, k8sutil.NewMemoryAllocator(), nil, informer.Core().V1().Namespaces())
+
+	// The Namespace was deleted before it could be parked.
+	controller.queue.AddRateLimited("test")
+	assert.Nil(t, controller.retry("test", fmt.Errorf("failed")))
+	assert.Equal(t, 0, controller.queue.NumRequeues("test"))
+	assert.Equal(t, 0, controller.parker.Len())
+}
+
+func TestNamespaceRetry(t *testing.T) {
+	cfg := &config.Config{PrivatePool: "default"}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test", ResourceVersion: "1"}}
+	clientset := fake.NewSimpleClientset(ns)
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	assert.Nil(t, informer.Core().V1().Namespaces().Informer().GetIndexer().Add(ns))
+	controller := NewController(cfg, clientset, k8sutil.NewMemoryAllocator(), nil, informer.Core().V1().Namespaces())
+
+	// Transient errors are retried forever.
+	for i := 0; i < 20; i++ {
+		assert.NotNil(t, controller.retry("test", fmt.Errorf("timeout")))
+	}
+	assert.Equal(t, 20, controller.queue.NumRequeues("test"))
+	assert.Equal(t, 0, controller.parker.Len())
+
+	// A permanent error parks the key at once.
+	assert.NotNil(t, controller.retry("test", k8sutil.Permanent(fmt.Errorf("pool not found"))))
+	assert.Equal(t, 0, controller.queue.NumRequeues("test"))
+	assert.Equal(t, 1, controller.parker.Len())
+
+	gns, err := clientset.CoreV1().Namespaces().Get("test", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "pool not found", gns.Annotations[constants.ReconcileFailedKey])
+}
+
+func TestNumberOfIPs(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: map[string]string{}}}
+	number, err := numberOfIPs(constants.DefaultKeys, ns)
+	assert.Nil(t, err)
+	assert.Equal(t, constants.DefaultNumberOfIP, number)
+
+	ns.Annotations[constants.NumberOfIPKey] = "3"
+	number, err = numberOfIPs(constants.DefaultKeys, ns)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, number)
+
+	// An invalid number is not replaced by the default, the Namespace is parked instead.
+	for _, value := range []string{"three", "-1"} {
+		ns.Annotations[constants.NumberOfIPKey] = value
+		_, err = numberOfIPs(constants.DefaultKeys, ns)
+		assert.True(t, k8sutil.IsPermanent(err))
+		assert.Equal(t, value, ns.Annotations[constants.NumberOfIPKey])
+	}
+}
+
+func TestNamespaceAndIPClaimSharePool(t *testing.T) {
+	cfg := &config.Config{PrivatePool: "test"}
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.15"}, AssignToNamespace: true},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	for i := 0; i < 2; i++ {
+		_, err := allocator.Allocate(&k8sutil.Request{
+			Name:  fmt.Sprintf("claim-test-vm-%d", i),
+			Pool:  pool.Name,
+			Owner: k8sutil.Owner{Kind: constants.OwnerKindIPClaim, Namespace: "test", Name: "test-vm"},
+		})
+		assert.Nil(t, err)
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test",
+		Annotations: map[string]string{constants.NumberOfIPKey: "1", constants.PrivatePoolKey: pool.Name},
+	}}
+	clientset := fake.NewSimpleClientset(ns)
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(cfg, clientset, allocator, nil, informer.Core().V1().Namespaces())
+
+	// The IPs of the claim are neither released nor published by the Namespace.
+	nsCopy := ns.DeepCopy()
+	assert.Nil(t, controller.syncIPs(nsCopy, pool.Name, 1))
+	updated, err := controller.updateStatus(ns, nsCopy, pool.Name, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "172.22.132.12", updated.Annotations[constants.IPsKey])
+
+	ips, err := allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 3)
+
+	owned, err := controller.listIPs(ns)
+	assert.Nil(t, err)
+	assert.Len(t, owned.Items, 1)
+	assert.Equal(t, "test-test-0", owned.Items[0].Name)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"fmt"
+	"strings"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/thoas/go-funk"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// addresses splits a comma-separated list of addresses.
+func addresses(value string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(value, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// releaseCandidates returns the IPs in the order of the release strategy, protected IPs are never returned.
+func releaseCandidates(keys *constants.Keys, ns *v1.Namespace, ips []blendedv1.IP) ([]blendedv1.IP, string, bool) {
+	protected := addresses(ns.Annotations[keys.ProtectedIPsKey])
+	var candidates []blendedv1.IP
+	for _, ip := range ips {
+		if !funk.ContainsString(protected, ip.Status.Address) {
+			candidates = append(candidates, ip)
+		}
+	}
+
+	strategy := ns.Annotations[keys.ReleaseStrategyKey]
+	switch strategy {
+	case "", constants.ReleaseNewestFirst:
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+		return candidates, constants.ReleaseNewestFirst, true
+	case constants.ReleaseOldestFirst:
+		return candidates, strategy, true
+	case constants.ReleaseExplicit:
+		listed := addresses(ns.Annotations[keys.ReleaseIPsKey])
+		var explicit []blendedv1.IP
+		for _, ip := range candidates {
+			if funk.ContainsString(listed, ip.Status.Address) {
+				explicit = append(explicit, ip)
+			}
+		}
+		return explicit, strategy, true
+	}
+	return nil, strategy, false
+}
+
+// scaleDown releases IPs until the namespace has the number of IPs. The IPs are sorted
+// from the oldest to the newest.
+func (c *Controller) scaleDown(ns *v1.Namespace, ips *blendedv1.IPList, number int, poolName string) error {
+	excess := len(ips.Items) - number
+	candidates, strategy, ok := releaseCandidates(c.keys, ns, ips.Items)
+	if !ok {
+		c.recorder.Eventf(ns, v1.EventTypeWarning, "InvalidReleaseStrategy",
+			"Unknown release strategy '%s', no IPs were released", strategy)
+		return nil
+	}
+
+	if len(candidates) < excess {
+		c.recorder.Eventf(ns, v1.EventTypeWarning, "ReleaseBlocked",
+			"Only %d of %d IPs can be released by strategy %s, the others are protected or not listed", len(candidates), excess, strategy)
+		excess = len(candidates)
+	}
+
+	var released []string
+	var errs []error
+	for _, ip := range candidates[:excess] {
+		if err := c.allocator.Release(&ip); err != nil {
+			if !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to release IP '%s': %s", ip.Name, err.Error()))
+			}
+			continue
+		}
+		audit.Record(c.sink, c.event(ns, audit.ActionRelease, poolName, &ip, "number of IPs changed"))
+		released = append(released, ip.Status.Address)
+	}
+
+	if len(released) > 0 {
+		c.recorder.Eventf(ns, v1.EventTypeNormal, "ReleasedIPs",
+			"Released IPs %s by strategy %s", strings.Join(released, ","), strategy)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// release releases the IPs except the protected ones, which are kept and reported by a ReleaseBlocked event.
+// It returns the number of kept IPs.
+func (c *Controller) release(ns *v1.Namespace, ips []blendedv1.IP, reason string) (int, error) {
+	protected := addresses(ns.Annotations[c.keys.ProtectedIPsKey])
+	var kept []string
+	var errs []error
+	for _, ip := range ips {
+		if funk.ContainsString(protected, ip.Status.Address) {
+			kept = append(kept, ip.Status.Address)
+			continue
+		}
+		if err := c.allocator.Release(&ip); err != nil {
+			if !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to release IP '%s': %s", ip.Name, err.Error()))
+			}
+			continue
+		}
+		audit.Record(c.sink, c.event(ns, audit.ActionRelease, ip.Spec.PoolName, &ip, reason))
+	}
+
+	if len(kept) > 0 {
+		c.recorder.Eventf(ns, v1.EventTypeWarning, "ReleaseBlocked",
+			"Protected IPs %s were not released: %s", strings.Join(kept, ","), reason)
+	}
+	return len(kept), utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,187 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"testing"
+	"time"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReleaseCandidates(t *testing.T) {
+	var ips []blendedv1.IP
+	for _, addr := range []string{"172.22.132.10", "172.22.132.11", "172.22.132.12"} {
+		ips = append(ips, blendedv1.IP{Status: blendedv1.IPStatus{Address: addr}})
+	}
+
+	tests := []struct {
+		annotations map[string]string
+		expected    []string
+		ok          bool
+	}{
+		{
+			annotations: map[string]string{},
+			expected:    []string{"172.22.132.12", "172.22.132.11", "172.22.132.10"},
+			ok:          true,
+		},
+		{
+			annotations: map[string]string{constants.ReleaseStrategyKey: constants.ReleaseOldestFirst},
+			expected:    []string{"172.22.132.10", "172.22.132.11", "172.22.132.12"},
+			ok:          true,
+		},
+		{
+			annotations: map[string]string{
+				constants.ReleaseStrategyKey: constants.ReleaseNewestFirst,
+				constants.ProtectedIPsKey:    "172.22.132.12, 172.22.132.10",
+			},
+			expected: []string{"172.22.132.11"},
+			ok:       true,
+		},
+		{
+			annotations: map[string]string{
+				constants.ReleaseStrategyKey: constants.ReleaseExplicit,
+				constants.ReleaseIPsKey:      "172.22.132.10,172.22.132.12",
+				constants.ProtectedIPsKey:    "172.22.132.12",
+			},
+			expected: []string{"172.22.132.10"},
+			ok:       true,
+		},
+		{
+			annotations: map[string]string{constants.ReleaseStrategyKey: "Random"},
+			ok:          false,
+		},
+	}
+
+	for _, test := range tests {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: test.annotations}}
+		candidates, _, ok := releaseCandidates(constants.DefaultKeys, ns, ips)
+		assert.Equal(t, test.ok, ok)
+
+		var addrs []string
+		for _, ip := range candidates {
+			addrs = append(addrs, ip.Status.Address)
+		}
+		assert.Equal(t, test.expected, addrs)
+	}
+}
+
+func TestScaleDown(t *testing.T) {
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"172.22.132.10-172.22.132.15"}},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	owner := k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"}
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := allocator.Allocate(&k8sutil.Request{Name: name, Pool: pool.Name, Owner: owner})
+		assert.Nil(t, err)
+	}
+
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(&config.Config{}, clientset, allocator, nil, informer.Core().V1().Namespaces())
+
+	// The newest IP is protected, so the one before it is released.
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test",
+			Annotations: map[string]string{constants.ProtectedIPsKey: "172.22.132.12"},
+		},
+	}
+	ips, err := allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Nil(t, controller.scaleDown(ns, ips, 2, pool.Name))
+
+	ips, err = allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 2)
+	for _, ip := range ips.Items {
+		assert.NotEqual(t, "172.22.132.11", ip.Status.Address)
+	}
+
+	// Protected IPs are kept even if the number cannot be reached.
+	ns.Annotations[constants.ProtectedIPsKey] = "172.22.132.10,172.22.132.12"
+	assert.Nil(t, controller.scaleDown(ns, ips, 0, pool.Name))
+	ips, err = allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 2)
+}
+
+func TestMigrateKeepsProtectedIPs(t *testing.T) {
+	newPool := func(name, addresses string) *blendedv1.Pool {
+		return &blendedv1.Pool{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       blendedv1.PoolSpec{Addresses: []string{addresses}},
+		}
+	}
+	allocator := k8sutil.NewMemoryAllocator(newPool("old", "172.22.132.10-172.22.132.15"), newPool("new", "172.22.133.10-172.22.133.15"))
+	owner := k8sutil.Owner{Kind: constants.OwnerKindNamespace, Namespace: "test", Name: "test"}
+	for _, pool := range []string{"old", "new"} {
+		_, err := allocator.Allocate(&k8sutil.Request{Name: k8sutil.NamespaceIPName("test", pool, 0), Pool: pool, Owner: owner})
+		assert.Nil(t, err)
+	}
+
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(&config.Config{}, clientset, allocator, nil, informer.Core().V1().Namespaces())
+
+	// The protected IP keeps the old pool until it is unprotected.
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			Annotations: map[string]string{
+				constants.LatestPoolKey:   "old",
+				constants.DrainUntilKey:   time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+				constants.ProtectedIPsKey: "172.22.132.10",
+			},
+		},
+	}
+	wait, err := controller.migrate(ns, "new", "old", 1)
+	assert.Nil(t, err)
+	assert.Zero(t, wait)
+	assert.Equal(t, "old", ns.Annotations[constants.LatestPoolKey])
+
+	ips, err := allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 2)
+
+	// A stale pool is not released either.
+	assert.Nil(t, controller.releaseStaleIPs(ns, "new"))
+	ips, err = allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 2)
+
+	delete(ns.Annotations, constants.ProtectedIPsKey)
+	_, err = controller.migrate(ns, "new", "old", 1)
+	assert.Nil(t, err)
+	assert.Empty(t, ns.Annotations[constants.LatestPoolKey])
+	assert.Equal(t, "new", ns.Annotations[constants.AssignedPoolKey])
+
+	ips, err = allocator.List(ns.Name)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 1)
+	assert.Equal(t, "new", ips.Items[0].Spec.PoolName)
+}
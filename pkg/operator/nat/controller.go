@@ -0,0 +1,246 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/firewall"
+	"github.com/inwinstack/ip-assigner/pkg/health"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	informerv1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// All Service events are collapsed into one key, since the mappings are always rebuilt as a whole.
+const syncKey = "nat-mappings"
+
+// Controller represents the controller of NAT mappings
+type Controller struct {
+	cfg  *config.Config
+	keys *constants.Keys
+
+	clientset kubernetes.Interface
+	provider  firewall.Provider
+	lister    listerv1.ServiceLister
+	synced    cache.InformerSynced
+	queue     workqueue.RateLimitingInterface
+	workers   *k8sutil.Workers
+	tracker   *health.Tracker
+}
+
+// NewController creates an instance of the NAT mapping controller
+func NewController(
+	cfg *config.Config,
+	clientset kubernetes.Interface,
+	provider firewall.Provider,
+	informer informerv1.ServiceInformer) *Controller {
+	controller := &Controller{
+		cfg:       cfg,
+		keys:      cfg.Keys(),
+		clientset: clientset,
+		provider:  provider,
+		lister:    informer.Lister(),
+		synced:    informer.Informer().HasSynced,
+		queue:     workqueue.NewNamedRateLimitingQueue(k8sutil.NewRateLimiter(cfg.Controller(config.NATController)), "NATMappings"),
+	}
+	controller.tracker = health.NewTracker("NATMappings", controller.queue.Len, controller.synced)
+	controller.workers = k8sutil.NewWorkers(controller.tracker.Name(), controller.tracker)
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueue,
+		UpdateFunc: func(old, new interface{}) {
+			controller.enqueue(new)
+		},
+		DeleteFunc: controller.enqueue,
+	})
+	return controller
+}
+
+// Run serves the NAT mapping controller
+func (c *Controller) Run(ctx context.Context) error {
+	glog.Info("Starting NAT mapping controller")
+	glog.Info("Waiting for NAT mapping informer caches to sync")
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.synced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	c.workers.Go(ctx, c.runWorker, time.Second)
+	return nil
+}
+
+// Stop stops the NAT mapping controller
+func (c *Controller) Stop() {
+	glog.Info("Stopping the NAT mapping controller")
+	c.queue.ShutDown()
+}
+
+// Wait waits for the in-flight reconciles to finish, the keys which are still reconciled after the timeout are abandoned.
+func (c *Controller) Wait(timeout time.Duration) {
+	c.workers.Wait(timeout)
+}
+
+// Tracker returns the tracker of the NAT mapping controller
+func (c *Controller) Tracker() *health.Tracker {
+	return c.tracker
+}
+
+func (c *Controller) runWorker() {
+	defer utilruntime.HandleCrash()
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() bool {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+
+	// The queued keys are dropped once the controller is stopped.
+	if c.queue.ShuttingDown() {
+		c.queue.Done(obj)
+		return false
+	}
+	defer c.queue.Done(obj)
+
+	c.tracker.Start(syncKey)
+	err := c.reconcile()
+	c.tracker.Finish(syncKey, err)
+	if err != nil {
+		c.queue.AddRateLimited(obj)
+		utilruntime.HandleError(fmt.Errorf("NAT mapping controller error syncing: %s, requeuing", err.Error()))
+		return true
+	}
+
+	c.queue.Forget(obj)
+	glog.V(2).Infof("NAT mapping controller successfully synced")
+	return true
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	c.queue.Add(syncKey)
+}
+
+func (c *Controller) reconcile() error {
+	svcs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	// The Services of other assigner classes are mapped by other instances.
+	var handled []*v1.Service
+	for _, svc := range svcs {
+		if c.cfg.Handles(svc.Annotations) {
+			handled = append(handled, svc)
+		}
+	}
+
+	mappings := Mappings(c.keys, handled)
+	if c.cfg.NATConfigMap != "" {
+		if err := c.updateConfigMap(mappings); err != nil {
+			return err
+		}
+	}
+
+	if c.provider != nil {
+		return c.provider.Sync(mappings)
+	}
+	return nil
+}
+
+func (c *Controller) updateConfigMap(mappings []firewall.Mapping) error {
+	data := map[string]string{}
+	for _, m := range mappings {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		data[m.Name] = string(b)
+	}
+
+	cms := c.clientset.CoreV1().ConfigMaps(c.cfg.NATNamespace)
+	cm, err := cms.Get(c.cfg.NATConfigMap, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.cfg.NATConfigMap,
+				Namespace: c.cfg.NATNamespace,
+			},
+			Data: data,
+		}
+		_, err = cms.Create(cm)
+		return err
+	}
+
+	if reflect.DeepEqual(cm.Data, data) || (len(cm.Data) == 0 && len(data) == 0) {
+		return nil
+	}
+
+	cmCopy := cm.DeepCopy()
+	cmCopy.Data = data
+	_, err = cms.Update(cmCopy)
+	return err
+}
+
+// Mappings returns the NAT mappings of Services which have a private and an allocated public address.
+func Mappings(keys *constants.Keys, svcs []*v1.Service) []firewall.Mapping {
+	mappings := []firewall.Mapping{}
+	for _, svc := range svcs {
+		if !svc.ObjectMeta.DeletionTimestamp.IsZero() || len(svc.Spec.ExternalIPs) == 0 {
+			continue
+		}
+
+		private := net.ParseIP(svc.Spec.ExternalIPs[0])
+		public := net.ParseIP(svc.Annotations[keys.PublicIPKey])
+		if private == nil || public == nil {
+			continue
+		}
+
+		mappings = append(mappings, firewall.Mapping{
+			Name:      fmt.Sprintf("%s-%s-%s", constants.PolicyPrefix, svc.Namespace, svc.Name),
+			Namespace: svc.Namespace,
+			Service:   svc.Name,
+			PrivateIP: private.String(),
+			PublicIP:  public.String(),
+		})
+	}
+
+	sort.Slice(mappings, func(i, j int) bool {
+		return mappings[i].Name < mappings[j].Name
+	})
+	return mappings
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nat
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/firewall"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const timeout = time.Second * 3
+
+type fakeProvider struct {
+	sync.Mutex
+	mappings []firewall.Mapping
+}
+
+func (p *fakeProvider) Sync(mappings []firewall.Mapping) error {
+	p.Lock()
+	defer p.Unlock()
+	p.mappings = mappings
+	return nil
+}
+
+func (p *fakeProvider) get() []firewall.Mapping {
+	p.Lock()
+	defer p.Unlock()
+	return p.mappings
+}
+
+func newService(name, private, public string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "test",
+			Annotations: map[string]string{constants.PublicIPKey: public},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{private},
+		},
+	}
+}
+
+func TestMappings(t *testing.T) {
+	svcs := []*corev1.Service{
+		newService("b", "172.22.132.11", "140.11.22.34"),
+		newService("a", "172.22.132.10", "140.11.22.33"),
+		newService("c", "172.22.132.12", ""),
+	}
+
+	expected := []firewall.Mapping{
+		{Name: "k8s-test-a", Namespace: "test", Service: "a", PrivateIP: "172.22.132.10", PublicIP: "140.11.22.33"},
+		{Name: "k8s-test-b", Namespace: "test", Service: "b", PrivateIP: "172.22.132.11", PublicIP: "140.11.22.34"},
+	}
+	assert.Equal(t, expected, Mappings(constants.DefaultKeys, svcs))
+}
+
+func TestNATController(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := &config.Config{
+		NATNamespace: "kube-system",
+		NATConfigMap: "ip-assigner-nat-mappings",
+	}
+
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	provider := &fakeProvider{}
+
+	controller := NewController(cfg, clientset, provider, informer.Core().V1().Services())
+	go informer.Start(ctx.Done())
+	assert.Nil(t, controller.Run(ctx))
+
+	svc := newService("test-svc", "172.22.132.10", "140.11.22.33")
+	_, err := clientset.CoreV1().Services(svc.Namespace).Create(svc)
+	assert.Nil(t, err)
+
+	failed := true
+	for start := time.Now(); time.Since(start) < timeout; {
+		cm, err := clientset.CoreV1().ConfigMaps(cfg.NATNamespace).Get(cfg.NATConfigMap, metav1.GetOptions{})
+		if err == nil {
+			if _, ok := cm.Data["k8s-test-test-svc"]; ok {
+				failed = false
+				break
+			}
+		}
+	}
+	assert.Equal(t, false, failed, "cannot get the NAT mapping.")
+
+	failed = true
+	for start := time.Now(); time.Since(start) < timeout; {
+		if len(provider.get()) == 1 {
+			assert.Equal(t, "140.11.22.33", provider.get()[0].PublicIP)
+			failed = false
+			break
+		}
+	}
+	assert.Equal(t, false, failed, "provider has not been synced.")
+
+	cancel()
+	controller.Stop()
+}
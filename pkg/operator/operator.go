@@ -19,58 +19,322 @@ package operator
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
+	"github.com/golang/glog"
 	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	"github.com/inwinstack/ip-assigner/pkg/adoption"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
 	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/firewall"
+	"github.com/inwinstack/ip-assigner/pkg/health"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/inwinstack/ip-assigner/pkg/notifier"
+	"github.com/inwinstack/ip-assigner/pkg/operator/gc"
+	"github.com/inwinstack/ip-assigner/pkg/operator/ipclaim"
 	"github.com/inwinstack/ip-assigner/pkg/operator/namespace"
+	"github.com/inwinstack/ip-assigner/pkg/operator/nat"
 	"github.com/inwinstack/ip-assigner/pkg/operator/service"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 )
 
-const defaultSyncTime = time.Second * 30
+const (
+	defaultSyncTime = time.Second * 30
+
+	// checkTimeout is the time the readiness checks may take.
+	checkTimeout = time.Second * 5
+)
 
 // Operator represents an operator context
 type Operator struct {
-	clientset  kubernetes.Interface
-	blendedset blended.Interface
-	informer   informers.SharedInformerFactory
+	clientset       kubernetes.Interface
+	dynamicset      dynamic.Interface
+	blendedset      blended.Interface
+	allocator       k8sutil.Allocator
+	sink            audit.Sink
+	notifier        *notifier.Notifier
+	health          *health.Server
+	informer        informers.SharedInformerFactory
+	dynamicInformer dynamicinformer.DynamicSharedInformerFactory
+
+	// The namespace-filtered informers of the watched namespaces.
+	nsInformers        []informers.SharedInformerFactory
+	nsDynamicInformers []dynamicinformer.DynamicSharedInformerFactory
 
 	cfg       *config.Config
 	namespace *namespace.Controller
-	service   *service.Controller
+	services  []*service.Controller
+	nat       *nat.Controller
+	ipclaims  []*ipclaim.Controller
+	gc        *gc.Controller
+}
+
+// NewAllocator creates the allocator for the IPAM mode of the config
+func NewAllocator(cfg *config.Config, blendedset blended.Interface) k8sutil.Allocator {
+	allocator := k8sutil.NewBlendedAllocator(blendedset, cfg.Keys())
+	if cfg.StandaloneIPAM {
+		allocator = k8sutil.NewStandaloneAllocator(blendedset, cfg.Keys())
+	}
+	if cfg.ClusterID != "" {
+		allocator = k8sutil.NewClusterAllocator(allocator, cfg.ClusterID, cfg.Keys())
+	}
+	return allocator
 }
 
 // New creates an instance of the operator
-func New(cfg *config.Config, clientset kubernetes.Interface, blendedset blended.Interface) *Operator {
-	o := &Operator{cfg: cfg, clientset: clientset, blendedset: blendedset}
+func New(cfg *config.Config, clientset kubernetes.Interface, dynamicset dynamic.Interface, blendedset blended.Interface) (*Operator, error) {
+	// The standalone allocator only locks its addresses within this process.
+	if cfg.StandaloneIPAM && cfg.ClusterID != "" {
+		return nil, fmt.Errorf("standalone IPAM cannot be used with a shared IPAM cluster")
+	}
+
+	o := &Operator{cfg: cfg, clientset: clientset, dynamicset: dynamicset, blendedset: blendedset}
+	o.allocator = NewAllocator(cfg, blendedset)
 	t := defaultSyncTime
 	if cfg.SyncSec > 30 {
 		t = time.Second * time.Duration(cfg.SyncSec)
 	}
 	o.informer = informers.NewSharedInformerFactory(clientset, t)
-	o.service = service.NewController(cfg, clientset, blendedset, o.informer.Core().V1().Services())
-	o.namespace = namespace.NewController(cfg, clientset, blendedset, o.informer.Core().V1().Namespaces())
-	return o
+	o.dynamicInformer = dynamicinformer.NewDynamicSharedInformerFactory(dynamicset, t)
+
+	var sinks []audit.Sink
+	if len(cfg.NotifyEndpoints) > 0 {
+		n, err := notifier.New(cfg.NotifyEndpoints, cfg.NotifySecret, cfg.NotifyQueueDir)
+		if err != nil {
+			return nil, err
+		}
+		o.notifier = n
+		sinks = append(sinks, n)
+	}
+	o.sink = newAuditSink(cfg, dynamicset, sinks...)
+	if cfg.Namespaced() {
+		o.newNamespacedControllers(t)
+	} else if err := o.newControllers(); err != nil {
+		return nil, err
+	}
+
+	o.health = health.NewServer(cfg.WedgeTimeout, checkTimeout)
+	for _, c := range o.services {
+		o.health.AddTracker(c.Tracker())
+	}
+	if o.namespace != nil {
+		o.health.AddTracker(o.namespace.Tracker())
+	}
+	for _, c := range o.ipclaims {
+		o.health.AddTracker(c.Tracker())
+	}
+	if o.nat != nil {
+		o.health.AddTracker(o.nat.Tracker())
+	}
+	o.health.AddCheck("blended", o.checkBlended)
+	return o, nil
+}
+
+// newControllers creates the enabled controllers, which watch all namespaces.
+func (o *Operator) newControllers() error {
+	cfg := o.cfg
+	if cfg.Enabled(config.ServiceController) {
+		o.services = append(o.services, service.NewController(cfg, o.clientset, o.allocator, o.sink, o.informer.Core().V1().Namespaces(), o.informer.Core().V1().Services()))
+	}
+
+	if cfg.Enabled(config.NamespaceController) {
+		o.namespace = namespace.NewController(cfg, o.clientset, o.allocator, o.sink, o.informer.Core().V1().Namespaces())
+	}
+
+	if cfg.EnableIPClaims && cfg.Enabled(config.IPClaimController) {
+		o.ipclaims = append(o.ipclaims, ipclaim.NewController(cfg, o.dynamicset, o.allocator, o.sink, o.dynamicInformer.ForResource(ipclaim.Resource)))
+	}
+
+	if cfg.GCInterval > 0 && cfg.Enabled(config.GCController) {
+		o.gc = gc.NewController(cfg, o.dynamicset, o.allocator, o.sink, o.informer.Core().V1().Namespaces(), o.informer.Core().V1().Services())
+	}
+
+	if (cfg.NATConfigMap != "" || cfg.FirewallProvider != "") && cfg.Enabled(config.NATController) {
+		provider, err := firewall.NewProvider(cfg.FirewallProvider, cfg.FirewallTarget)
+		if err != nil {
+			return err
+		}
+		o.nat = nat.NewController(cfg, o.clientset, provider, o.informer.Core().V1().Services())
+	}
+	return nil
+}
+
+// newNamespacedControllers creates a Service and an IPClaim controller for each watched namespace. The
+// Namespace, NAT mapping and garbage collector controllers need access to all namespaces, so they are disabled.
+func (o *Operator) newNamespacedControllers(resync time.Duration) {
+	for _, name := range []string{config.NamespaceController, config.NATController, config.GCController} {
+		if o.cfg.Enabled(name) {
+			glog.Warningf("The %s controller is disabled when watching namespaces %s", name, strings.Join(o.cfg.WatchNamespaces, ","))
+		}
+	}
+
+	for _, ns := range o.cfg.WatchNamespaces {
+		// Each controller only sees its own namespace.
+		cfg := *o.cfg
+		cfg.WatchNamespaces = []string{ns}
+
+		informer := informers.NewSharedInformerFactoryWithOptions(o.clientset, resync, informers.WithNamespace(ns))
+		dynamicInformer := dynamicinformer.NewFilteredDynamicSharedInformerFactory(o.dynamicset, resync, ns, nil)
+		o.nsInformers = append(o.nsInformers, informer)
+		o.nsDynamicInformers = append(o.nsDynamicInformers, dynamicInformer)
+
+		if cfg.Enabled(config.ServiceController) {
+			o.services = append(o.services, service.NewController(&cfg, o.clientset, o.allocator, o.sink, nil, informer.Core().V1().Services()))
+		}
+
+		if cfg.EnableIPClaims && cfg.Enabled(config.IPClaimController) {
+			o.ipclaims = append(o.ipclaims, ipclaim.NewController(&cfg, o.dynamicset, o.allocator, o.sink, dynamicInformer.ForResource(ipclaim.Resource)))
+		}
+	}
+}
+
+// Handler returns the handler of the health, readiness and debug endpoints
+func (o *Operator) Handler() http.Handler {
+	return o.health.Handler()
+}
+
+// checkBlended checks that the Pool resources of the IPAM cluster are reachable.
+func (o *Operator) checkBlended() error {
+	_, err := o.blendedset.InwinstackV1().Pools().List(metav1.ListOptions{Limit: 1})
+	return err
+}
+
+// newAuditSink creates the audit sink for the enabled audit logs and the given sinks, it returns nil if auditing is disabled.
+func newAuditSink(cfg *config.Config, dynamicset dynamic.Interface, sinks ...audit.Sink) audit.Sink {
+	if cfg.AuditFile != "" {
+		sinks = append(sinks, audit.NewFile(cfg.AuditFile, int64(cfg.AuditFileMaxSizeMB)*1024*1024, cfg.AuditFileMaxBackups))
+	}
+
+	if cfg.AuditHistory {
+		sinks = append(sinks, audit.NewHistory(dynamicset, cfg.Keys()))
+	}
+
+	if len(sinks) == 0 {
+		return nil
+	}
+	return audit.Multi(sinks...)
+}
+
+// adopt binds the existing addresses before the controllers allocate new ones.
+func (o *Operator) adopt() error {
+	if o.cfg.Namespaced() && (o.cfg.AdoptInventory != "" || o.cfg.AdoptUnlabelled) {
+		return fmt.Errorf("adopting needs access to all namespaces")
+	}
+
+	adopter := adoption.New(o.clientset, o.allocator, o.sink, o.cfg.Keys())
+	if o.cfg.AdoptInventory != "" {
+		inv, err := adoption.LoadInventory(o.cfg.AdoptInventory)
+		if err != nil {
+			return err
+		}
+		if err := adopter.AdoptInventory(inv); err != nil {
+			return err
+		}
+	}
+
+	if o.cfg.AdoptUnlabelled {
+		return adopter.AdoptUnlabelled()
+	}
+	return nil
 }
 
 // Run serves an isntance of the operator
 func (o *Operator) Run(ctx context.Context) error {
+	if err := o.adopt(); err != nil {
+		return fmt.Errorf("failed to adopt existing IPs: %s", err.Error())
+	}
+
 	go o.informer.Start(ctx.Done())
+	go o.dynamicInformer.Start(ctx.Done())
+	for i := range o.nsInformers {
+		go o.nsInformers[i].Start(ctx.Done())
+		go o.nsDynamicInformers[i].Start(ctx.Done())
+	}
+	if o.notifier != nil {
+		go o.notifier.Run(ctx.Done())
+	}
 
-	if err := o.service.Run(ctx, o.cfg.Threads); err != nil {
-		return fmt.Errorf("failed to run Service controller: %s", err.Error())
+	if len(o.services) > 0 {
+		o.logRBAC(config.ServiceController)
+	}
+	for _, c := range o.services {
+		if err := c.Run(ctx, o.cfg.Controller(config.ServiceController).Workers); err != nil {
+			return fmt.Errorf("failed to run Service controller: %s", err.Error())
+		}
+	}
+
+	if o.namespace != nil {
+		o.logRBAC(config.NamespaceController)
+		if err := o.namespace.Run(ctx, o.cfg.Controller(config.NamespaceController).Workers); err != nil {
+			return fmt.Errorf("failed to run Namespace controller: %s", err.Error())
+		}
+	}
+
+	if len(o.ipclaims) > 0 {
+		o.logRBAC(config.IPClaimController)
+	}
+	for _, c := range o.ipclaims {
+		if err := c.Run(ctx, o.cfg.Controller(config.IPClaimController).Workers); err != nil {
+			return fmt.Errorf("failed to run IPClaim controller: %s", err.Error())
+		}
+	}
+
+	if o.nat != nil {
+		o.logRBAC(config.NATController)
+		if err := o.nat.Run(ctx); err != nil {
+			return fmt.Errorf("failed to run NAT mapping controller: %s", err.Error())
+		}
 	}
 
-	if err := o.namespace.Run(ctx, o.cfg.Threads); err != nil {
-		return fmt.Errorf("failed to run Namespace controller: %s", err.Error())
+	if o.gc != nil {
+		o.logRBAC(config.GCController)
+		if err := o.gc.Run(ctx); err != nil {
+			return fmt.Errorf("failed to run IP garbage collector: %s", err.Error())
+		}
 	}
 	return nil
 }
 
-// Stop stops all controllers
+// Stop stops all controllers, and waits for the in-flight reconciles to finish
 func (o *Operator) Stop() {
-	o.service.Stop()
-	o.namespace.Stop()
+	for _, c := range o.services {
+		c.Stop()
+	}
+	if o.namespace != nil {
+		o.namespace.Stop()
+	}
+	for _, c := range o.ipclaims {
+		c.Stop()
+	}
+	if o.nat != nil {
+		o.nat.Stop()
+	}
+	if o.gc != nil {
+		o.gc.Stop()
+	}
+
+	// All controllers share the shutdown timeout.
+	deadline := time.Now().Add(o.cfg.ShutdownTimeout)
+	for _, c := range o.services {
+		c.Wait(time.Until(deadline))
+	}
+	if o.namespace != nil {
+		o.namespace.Wait(time.Until(deadline))
+	}
+	for _, c := range o.ipclaims {
+		c.Wait(time.Until(deadline))
+	}
+	if o.nat != nil {
+		o.nat.Wait(time.Until(deadline))
+	}
+	if o.gc != nil {
+		o.gc.Wait(time.Until(deadline))
+	}
+	glog.Info("All controllers stopped")
 }
@@ -20,6 +20,8 @@ import (
 	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
 	"github.com/inwinstack/ip-assigner/pkg/config"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -27,12 +29,34 @@ func TestOperator(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cfg := &config.Config{Threads: 2, SyncSec: 60}
 	clientset := fake.NewSimpleClientset()
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
 	blendedset := blendedfake.NewSimpleClientset()
 
-	op := New(cfg, clientset, blendedset)
+	op, err := New(cfg, clientset, dynamicset, blendedset)
+	assert.Nil(t, err)
 	assert.NotNil(t, op)
 	assert.Nil(t, op.Run(ctx))
 
 	cancel()
 	op.Stop()
 }
+
+func TestOperatorWithUnknownFirewall(t *testing.T) {
+	cfg := &config.Config{Threads: 2, FirewallProvider: "unknown"}
+	clientset := fake.NewSimpleClientset()
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	blendedset := blendedfake.NewSimpleClientset()
+
+	_, err := New(cfg, clientset, dynamicset, blendedset)
+	assert.NotNil(t, err)
+}
+
+func TestOperatorWithStandaloneSharedIPAM(t *testing.T) {
+	cfg := &config.Config{Threads: 2, StandaloneIPAM: true, ClusterID: "east"}
+	clientset := fake.NewSimpleClientset()
+	dynamicset := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	blendedset := blendedfake.NewSimpleClientset()
+
+	_, err := New(cfg, clientset, dynamicset, blendedset)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operator
+
+import (
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+)
+
+// rbac lists the permissions which each controller needs.
+var rbac = map[string][]string{
+	config.NamespaceController: {
+		"namespaces: get, list, watch, patch",
+		"events: create, patch",
+		"inwinstack.com ips: get, list, create, update, delete",
+		"inwinstack.com pools: get, list",
+	},
+	config.ServiceController: {
+		"services: get, list, watch, patch",
+		"namespaces: list, watch",
+		"events: create, patch",
+		"inwinstack.com ips: get, list, create, update, patch, delete",
+		"inwinstack.com pools: get, list",
+	},
+	config.IPClaimController: {
+		"inwinstack.com ipclaims, ipclaims/status: get, list, watch, update",
+		"inwinstack.com ips: get, list, create, update, delete",
+		"inwinstack.com pools: get, list",
+	},
+	config.NATController: {
+		"services: list, watch",
+		"configmaps in --nat-namespace: get, create, update",
+	},
+	config.GCController: {
+		"namespaces, services: list, watch",
+		"inwinstack.com ipclaims: get",
+		"inwinstack.com ips: list, delete",
+	},
+}
+
+// logRBAC logs the permissions which the controller needs.
+func (o *Operator) logRBAC(name string) {
+	glog.Infof("Controller %s requires RBAC permissions: %s", name, strings.Join(rbac[name], "; "))
+}
@@ -20,64 +20,116 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
-	blended "github.com/inwinstack/blended/generated/clientset/versioned"
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
 	blended_k8sutil "github.com/inwinstack/blended/k8sutil"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
 	"github.com/inwinstack/ip-assigner/pkg/config"
 	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/health"
 	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
 	"github.com/thoas/go-funk"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
 	informerv1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	listerv1 "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/util/workqueue"
+	"k8s.io/client-go/tools/record"
 )
 
+// parkCheckPeriod is the period of checking the pools of the parked Services.
+const parkCheckPeriod = time.Minute
+
 // Controller represents the controller of service
 type Controller struct {
-	clientset  kubernetes.Interface
-	blendedset blended.Interface
-	lister     listerv1.ServiceLister
-	synced     cache.InformerSynced
-	queue      workqueue.RateLimitingInterface
-	cfg        *config.Config
+	clientset kubernetes.Interface
+	allocator k8sutil.Allocator
+	sink      audit.Sink
+	lister    listerv1.ServiceLister
+	nsLister  listerv1.NamespaceLister
+	synced    cache.InformerSynced
+	queue     *k8sutil.PriorityQueue
+	workers   *k8sutil.Workers
+	tracker   *health.Tracker
+	latency   *k8sutil.Latency
+	parker    *k8sutil.Parker
+	hashes    *k8sutil.Hashes
+	recorder  record.EventRecorder
+	cfg       *config.Config
+	keys      *constants.Keys
+}
+
+// statusKeys returns the annotations which the controller writes, updating only them does not need a reconcile.
+func (c *Controller) statusKeys() []string {
+	return []string{
+		c.keys.PublicIPKey,
+		c.keys.PublicIPRefKey,
+		c.keys.MigrationPublicIPKey,
+		c.keys.MigrationPublicIPRefKey,
+		c.keys.DrainUntilKey,
+		c.keys.ReconcileFailedKey,
+	}
 }
 
-// NewController creates an instance of the service controller
+// NewController creates an instance of the service controller. The Namespace informer is nil when
+// watching namespaces, then the Services are not shared across namespaces.
 func NewController(
 	cfg *config.Config,
 	clientset kubernetes.Interface,
-	blendedset blended.Interface,
+	allocator k8sutil.Allocator,
+	sink audit.Sink,
+	nsInformer informerv1.NamespaceInformer,
 	informer informerv1.ServiceInformer) *Controller {
 	controller := &Controller{
-		cfg:        cfg,
-		clientset:  clientset,
-		blendedset: blendedset,
-		lister:     informer.Lister(),
-		synced:     informer.Informer().HasSynced,
-		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Services"),
+		cfg:       cfg,
+		clientset: clientset,
+		allocator: allocator,
+		sink:      sink,
+		lister:    informer.Lister(),
+		synced:    informer.Informer().HasSynced,
+		queue:     k8sutil.NewPriorityQueue(k8sutil.NewRateLimiter(cfg.Controller(config.ServiceController)), cfg.ScopedName("Services")),
+		latency:   k8sutil.NewFirstAllocation(cfg.ScopedName("Services")),
+		parker:    k8sutil.NewParker(allocator),
+		hashes:    k8sutil.NewHashes(),
+		recorder:  k8sutil.NewEventRecorder(clientset),
+		keys:      cfg.Keys(),
 	}
+	if nsInformer != nil {
+		controller.nsLister = nsInformer.Lister()
+		controller.synced = func() bool {
+			return informer.Informer().HasSynced() && nsInformer.Informer().HasSynced()
+		}
+	}
+	controller.tracker = health.NewTracker(cfg.ScopedName("Services"), controller.queue.Len, controller.synced)
+	controller.workers = k8sutil.NewWorkers(controller.tracker.Name(), controller.tracker)
 	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: controller.enqueue,
+		AddFunc: func(obj interface{}) {
+			// The Services waiting for a public IP go ahead of the ones listed again after a restart.
+			svc := obj.(*v1.Service)
+			controller.enqueue(svc, len(svc.Spec.ExternalIPs) > 0 && svc.Annotations[controller.keys.PublicIPKey] == "")
+		},
 		UpdateFunc: func(old, new interface{}) {
 			oo := old.(*v1.Service)
 			no := new.(*v1.Service)
-			ooPool := oo.Annotations[constants.PublicPoolKey]
-			noPool := no.Annotations[constants.PublicPoolKey]
-			if ooPool != noPool {
-				// Cannot change the pool name
-				no.Annotations[constants.PublicPoolKey] = ooPool
+			if reflect.DeepEqual(oo.Spec, no.Spec) && k8sutil.OnlyChanged(oo, no, controller.statusKeys()...) {
+				return
 			}
-			controller.enqueue(no)
+
+			ooPool := oo.Annotations[controller.keys.PublicPoolKey]
+			noPool := no.Annotations[controller.keys.PublicPoolKey]
+			if ooPool != noPool && oo.Annotations[controller.keys.MigrateToPoolKey] != noPool {
+				// Cannot change the pool name, except by a migration
+				no.Annotations[controller.keys.PublicPoolKey] = ooPool
+			}
+			// The changes go ahead of the resyncs.
+			controller.enqueue(no, oo.ResourceVersion != no.ResourceVersion)
 		},
 	})
 	return controller
@@ -91,8 +143,10 @@ func (c *Controller) Run(ctx context.Context, threadiness int) error {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 	for i := 0; i < threadiness; i++ {
-		go wait.Until(c.runWorker, time.Second, ctx.Done())
+		c.workers.Go(ctx, c.runWorker, time.Second)
 	}
+	c.workers.Go(ctx, c.releaseExpiredIPs, retainCheckPeriod)
+	c.workers.Go(ctx, c.retryParked, parkCheckPeriod)
 	return nil
 }
 
@@ -102,6 +156,16 @@ func (c *Controller) Stop() {
 	c.queue.ShutDown()
 }
 
+// Wait waits for the in-flight reconciles to finish, the keys which are still reconciled after the timeout are abandoned.
+func (c *Controller) Wait(timeout time.Duration) {
+	c.workers.Wait(timeout)
+}
+
+// Tracker returns the tracker of the Service controller
+func (c *Controller) Tracker() *health.Tracker {
+	return c.tracker
+}
+
 func (c *Controller) runWorker() {
 	defer utilruntime.HandleCrash()
 	for c.processNextWorkItem() {
@@ -114,6 +178,12 @@ func (c *Controller) processNextWorkItem() bool {
 		return false
 	}
 
+	// The queued keys are dropped once the controller is stopped.
+	if c.queue.ShuttingDown() {
+		c.queue.Done(obj)
+		return false
+	}
+
 	err := func(obj interface{}) error {
 		defer c.queue.Done(obj)
 		key, ok := obj.(string)
@@ -123,9 +193,11 @@ func (c *Controller) processNextWorkItem() bool {
 			return nil
 		}
 
-		if err := c.reconcile(key); err != nil {
-			c.queue.AddRateLimited(key)
-			return fmt.Errorf("Service controller error syncing '%s': %s, requeuing", key, err.Error())
+		c.tracker.Start(key)
+		err := c.reconcile(key)
+		c.tracker.Finish(key, err)
+		if err != nil {
+			return c.retry(key, err)
 		}
 
 		c.queue.Forget(obj)
@@ -140,15 +212,103 @@ func (c *Controller) processNextWorkItem() bool {
 	return true
 }
 
-func (c *Controller) enqueue(obj interface{}) {
+// enqueue queues the key of the object, the keys with priority are reconciled first.
+func (c *Controller) enqueue(obj interface{}, priority bool) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	// The objects of other assigner classes are handled by other instances.
+	if !c.cfg.Handles(m.GetAnnotations()) {
+		return
+	}
+
 	key, err := cache.MetaNamespaceKeyFunc(obj)
 	if err != nil {
 		utilruntime.HandleError(err)
 		return
 	}
+
+	if c.parker.Parked(key, m.GetResourceVersion()) {
+		return
+	}
+	if priority {
+		c.queue.AddPriority(key)
+		return
+	}
 	c.queue.Add(key)
 }
 
+// retry requeues the failed key, or parks it on a permanent error or after the max retries. Transient errors,
+// e.g. timeouts, conflicts or an unreachable IPAM, are retried with backoff until they go away.
+func (c *Controller) retry(key string, reconcileErr error) error {
+	permanent := k8sutil.IsPermanent(reconcileErr)
+	if !permanent && (c.cfg.MaxRetries <= 0 || c.queue.NumRequeues(key) < c.cfg.MaxRetries) {
+		c.queue.AddRateLimited(key)
+		return fmt.Errorf("Service controller error syncing '%s': %s, requeuing", key, reconcileErr.Error())
+	}
+
+	if err := c.park(key, reconcileErr); err != nil {
+		if errors.IsNotFound(err) {
+			// The Service was deleted meanwhile, so there is nothing left to retry.
+			c.forget(key)
+			return nil
+		}
+		c.queue.AddRateLimited(key)
+		return fmt.Errorf("Service controller failed to park '%s': %s, requeuing", key, err.Error())
+	}
+	c.queue.Forget(key)
+	return fmt.Errorf("Service controller error syncing '%s': %s, parked", key, reconcileErr.Error())
+}
+
+// forget drops the state of the key, once its object is gone.
+func (c *Controller) forget(key string) {
+	c.queue.Forget(key)
+	c.parker.Forget(key)
+	c.hashes.Delete(key)
+}
+
+// park records the error on the Service, and parks the key until the Service or its pool changes.
+func (c *Controller) park(key string, reconcileErr error) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.lister.Services(namespace).Get(name)
+	if err != nil {
+		return err
+	}
+
+	objCopy := obj.DeepCopy()
+	if objCopy.Annotations == nil {
+		objCopy.Annotations = map[string]string{}
+	}
+	objCopy.Annotations[c.keys.ReconcileFailedKey] = reconcileErr.Error()
+	updated, err := k8sutil.PatchService(c.clientset, obj, objCopy)
+	if err != nil {
+		return err
+	}
+
+	pool := updated.Annotations[c.keys.PublicPoolKey]
+	if pool == "" {
+		pool = c.cfg.PublicPool
+	}
+	c.parker.Park(key, updated.ResourceVersion, pool)
+	c.recorder.Eventf(updated, v1.EventTypeWarning, "ReconcileFailed",
+		"Giving up until the Service or pool '%s' changes: %s", pool, reconcileErr.Error())
+	return nil
+}
+
+// retryParked retries the parked keys whose pool was changed.
+func (c *Controller) retryParked() {
+	for _, key := range c.parker.PoolChanged() {
+		c.queue.Add(key)
+	}
+}
+
 func (c *Controller) reconcile(key string) error {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
@@ -156,7 +316,7 @@ func (c *Controller) reconcile(key string) error {
 		return err
 	}
 
-	svc, err := c.lister.Services(namespace).Get(name)
+	obj, err := c.lister.Services(namespace).Get(name)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			utilruntime.HandleError(fmt.Errorf("service '%s' in work queue no longer exists", key))
@@ -165,105 +325,227 @@ func (c *Controller) reconcile(key string) error {
 		return err
 	}
 
+	// The changes to the copy are patched.
+	svc := obj.DeepCopy()
+
 	// If service was deleted, it will clean up IP.
 	if !svc.ObjectMeta.DeletionTimestamp.IsZero() {
+		c.hashes.Delete(key)
 		return c.cleanup(svc)
 	}
 
+	// The sharers are checked on every reconcile, since another sharer may have been changed.
+	if svc.Annotations[c.keys.SharingKey] != "" && net.ParseIP(svc.Annotations[c.keys.PublicIPKey]) != nil {
+		if err := c.checkSharing(svc); err != nil {
+			if err == errSharingConflict {
+				// Wait for the Services to be changed, the conflict was reported by an event.
+				return nil
+			}
+			return err
+		}
+	}
+
+	// Nothing changed since the last reconcile.
+	hash := inputHash(obj)
+	if c.hashes.Applied(key, hash) {
+		glog.V(4).Infof("Service controller skipped '%s', nothing changed", key)
+		return nil
+	}
+
 	c.makeDefaultPool(svc)
 	if len(svc.Spec.ExternalIPs) == 0 {
 		return nil
 	}
 
 	if err := c.allocate(svc); err != nil {
+		if err == errSharingConflict {
+			// Wait for the Service to be changed, the conflict was reported by an event.
+			return nil
+		}
 		return err
 	}
 
-	address := net.ParseIP(svc.Annotations[constants.PublicIPKey])
+	address := net.ParseIP(svc.Annotations[c.keys.PublicIPKey])
 	if address == nil {
 		return fmt.Errorf("failed to get the public IP")
 	}
 
-	svcCopy := svc.DeepCopy()
-	if !funk.ContainsString(svcCopy.Finalizers, constants.Finalizer) {
-		blended_k8sutil.AddFinalizer(&svcCopy.ObjectMeta, constants.Finalizer)
+	requeueAfter, err := c.migrate(svc)
+	if err != nil {
+		return err
 	}
 
-	if _, err := c.clientset.CoreV1().Services(svcCopy.Namespace).Update(svcCopy); err != nil {
+	delete(svc.Annotations, c.keys.ReconcileFailedKey)
+	if !funk.ContainsString(svc.Finalizers, constants.Finalizer) {
+		blended_k8sutil.AddFinalizer(&svc.ObjectMeta, constants.Finalizer)
+	}
+
+	// Nothing is written if nothing changed.
+	updated, err := k8sutil.PatchService(c.clientset, obj, svc)
+	if err != nil {
 		return err
 	}
+	if obj.Annotations[c.keys.PublicIPKey] == "" {
+		c.latency.ObserveSince(obj.CreationTimestamp.Time)
+	}
+	if requeueAfter > 0 {
+		c.hashes.Delete(key)
+		c.queue.AddAfter(key, requeueAfter)
+		return nil
+	}
+	c.hashes.Set(key, inputHash(updated))
 	return nil
 }
 
+// inputHash returns the hash of the inputs of a reconcile.
+func inputHash(svc *v1.Service) string {
+	return k8sutil.Hash(svc.Annotations, svc.Finalizers, svc.Spec.ExternalIPs)
+}
+
 func (c *Controller) makeDefaultPool(svc *v1.Service) {
 	if svc.Annotations == nil {
 		svc.Annotations = map[string]string{}
 	}
 
-	if _, ok := svc.Annotations[constants.PublicPoolKey]; !ok {
-		svc.Annotations[constants.PublicPoolKey] = c.cfg.PublicPool
+	if _, ok := svc.Annotations[c.keys.PublicPoolKey]; !ok {
+		svc.Annotations[c.keys.PublicPoolKey] = c.cfg.PublicPool
 	}
 }
 
 func (c *Controller) allocate(svc *v1.Service) error {
-	pool := svc.Annotations[constants.PublicPoolKey]
-	address := net.ParseIP(svc.Annotations[constants.PublicIPKey])
+	pool := svc.Annotations[c.keys.PublicPoolKey]
+	address := net.ParseIP(svc.Annotations[c.keys.PublicIPKey])
 	if address == nil && len(pool) > 0 {
-		name := svc.Spec.ExternalIPs[0]
-		ip, err := c.blendedset.InwinstackV1().IPs(svc.Namespace).Get(name, metav1.GetOptions{})
+		if _, ok := svc.Annotations[c.keys.PublicIPRefKey]; !ok {
+			ip, err := c.reattach(svc)
+			if err != nil {
+				return err
+			}
+			if ip != nil {
+				svc.Annotations[c.keys.PublicIPKey] = ip.Status.Address
+				svc.Annotations[c.keys.PublicIPRefKey] = ip.Name
+				audit.Record(c.sink, c.event(svc, audit.ActionAssign, ip, "re-attached retained IP"))
+				return nil
+			}
+
+			if svc.Annotations[c.keys.SharingKey] != "" {
+				shared, err := c.share(svc)
+				if err != nil || shared {
+					return err
+				}
+			}
+		}
+
+		namespace, name := IPRef(c.keys, svc)
+		ip, err := c.allocator.Get(namespace, name)
 		if err == nil {
+			// An IP which is retained for another claim is not taken over.
+			if ip.Labels[c.keys.RetainedLabel] == "true" {
+				if ip, err = c.rebind(svc, ip); err != nil {
+					return err
+				}
+			}
 			if net.ParseIP(ip.Status.Address) != nil {
-				svc.Annotations[constants.PublicIPKey] = ip.Status.Address
+				svc.Annotations[c.keys.PublicIPKey] = ip.Status.Address
+				svc.Annotations[c.keys.PublicIPRefKey] = ip.Name
+				audit.Record(c.sink, c.event(svc, audit.ActionAssign, ip, "address published"))
 			}
 			return nil
 		}
 
-		if _, err := k8sutil.NewIP(c.blendedset, name, svc.Namespace, pool); err != nil {
+		req := &k8sutil.Request{
+			Name:  name,
+			Pool:  pool,
+			Owner: k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: svc.Namespace, Name: svc.Name},
+		}
+		ip, err = c.allocator.Allocate(req)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				// The pool, or the namespace in the IPAM cluster, is missing.
+				return k8sutil.Permanent(err)
+			}
 			return err
 		}
+		audit.Record(c.sink, c.event(svc, audit.ActionAllocate, ip, "external IP added"))
 		return fmt.Errorf("public IP has been allocated, but cannot get")
 	}
 	return nil
 }
 
-func (c *Controller) deallocate(svc *v1.Service) error {
-	name := svc.Spec.ExternalIPs[0]
-	if _, err := c.blendedset.InwinstackV1().IPs(svc.Namespace).Get(name, metav1.GetOptions{}); err != nil {
+func (c *Controller) deallocate(svc *v1.Service, reason string) error {
+	namespace, name := IPRef(c.keys, svc)
+	ip, err := c.allocator.Get(namespace, name)
+	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			return nil
 		}
 		return err
 	}
-	return c.blendedset.InwinstackV1().IPs(svc.Namespace).Delete(name, nil)
+
+	if err := c.allocator.Release(ip); err != nil {
+		return err
+	}
+	audit.Record(c.sink, c.event(svc, audit.ActionRelease, ip, reason))
+	return nil
+}
+
+func (c *Controller) event(svc *v1.Service, action string, ip *blendedv1.IP, reason string) *audit.Event {
+	e := &audit.Event{
+		Action:    action,
+		OwnerKind: constants.OwnerKindService,
+		Namespace: svc.Namespace,
+		Name:      svc.Name,
+		Pool:      svc.Annotations[c.keys.PublicPoolKey],
+		Address:   svc.Annotations[c.keys.PublicIPKey],
+		Reason:    reason,
+	}
+	if ip != nil {
+		e.IP = ip.Name
+		e.Address = ip.Status.Address
+	}
+	return e
 }
 
 func (c *Controller) cleanup(svc *v1.Service) error {
 	svcCopy := svc.DeepCopy()
-	address := net.ParseIP(svcCopy.Annotations[constants.PublicIPKey])
+	address := net.ParseIP(svcCopy.Annotations[c.keys.PublicIPKey])
 	if address == nil {
 		return nil
 	}
 
-	svcs, err := c.clientset.CoreV1().Services(svcCopy.Namespace).List(metav1.ListOptions{})
-	if err != nil {
+	if err := c.releaseMigrationIP(svcCopy); err != nil {
 		return err
 	}
 
-	items := funk.Filter(svcs.Items, func(s v1.Service) bool {
-		v := s.Annotations[constants.PublicIPKey]
-		return v == address.String()
-	})
+	shared, err := c.inUse(svcCopy)
+	if err != nil {
+		return err
+	}
 
-	// If this namespace has other services are used the same public IP,
+	// If other services are used the same public IP,
 	// it will not release this public IP
-	if len(items.([]v1.Service)) > 1 {
+	if shared {
 		if err := c.removeFinalizer(svcCopy); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	if err := c.deallocate(svcCopy); err != nil {
+	policy, period, err := parseRetainPolicy(svcCopy.Annotations[c.keys.RetainPolicyKey])
+	if err != nil {
+		// Keep the IP when the policy is unclear, releasing it cannot be undone.
+		utilruntime.HandleError(fmt.Errorf("service '%s/%s': %s, retaining the public IP", svcCopy.Namespace, svcCopy.Name, err.Error()))
+		policy = constants.RetainPolicyRetain
+	}
+
+	if policy != constants.RetainPolicyDelete {
+		if err := c.retain(svcCopy, period); err != nil {
+			return err
+		}
+		return c.removeFinalizer(svcCopy)
+	}
+
+	if err := c.deallocate(svcCopy, "service deleted"); err != nil {
 		return err
 	}
 	glog.V(3).Infof("Service controller has been deleted IP.")
@@ -271,8 +553,9 @@ func (c *Controller) cleanup(svc *v1.Service) error {
 }
 
 func (c *Controller) removeFinalizer(svc *v1.Service) error {
-	blended_k8sutil.RemoveFinalizer(&svc.ObjectMeta, constants.Finalizer)
-	if _, err := c.clientset.CoreV1().Services(svc.Namespace).Update(svc); err != nil {
+	svcCopy := svc.DeepCopy()
+	blended_k8sutil.RemoveFinalizer(&svcCopy.ObjectMeta, constants.Finalizer)
+	if _, err := k8sutil.PatchService(c.clientset, svc, svcCopy); err != nil {
 		return err
 	}
 	return nil
@@ -18,6 +18,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -25,6 +26,7 @@ import (
 	blendedfake "github.com/inwinstack/blended/generated/clientset/versioned/fake"
 	"github.com/inwinstack/ip-assigner/pkg/config"
 	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -45,7 +47,8 @@ func TestServiceController(t *testing.T) {
 	blendedset := blendedfake.NewSimpleClientset()
 	informer := informers.NewSharedInformerFactory(clientset, 0)
 
-	controller := NewController(cfg, clientset, blendedset, informer.Core().V1().Services())
+	allocator := k8sutil.NewBlendedAllocator(blendedset, constants.DefaultKeys)
+	controller := NewController(cfg, clientset, allocator, nil, informer.Core().V1().Namespaces(), informer.Core().V1().Services())
 	go informer.Start(ctx.Done())
 	assert.Nil(t, controller.Run(ctx, cfg.Threads))
 
@@ -119,3 +122,38 @@ func TestServiceController(t *testing.T) {
 	cancel()
 	controller.Stop()
 }
+
+func TestAssignerClass(t *testing.T) {
+	cfg := &config.Config{AssignerClass: "zone-a"}
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(cfg, clientset, k8sutil.NewMemoryAllocator(), nil, informer.Core().V1().Namespaces(), informer.Core().V1().Services())
+
+	newService := func(name, class string) *corev1.Service {
+		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Annotations: map[string]string{}}}
+		if class != "" {
+			svc.Annotations[constants.AssignerClassKey] = class
+		}
+		return svc
+	}
+
+	controller.enqueue(newService("none", ""), false)
+	controller.enqueue(newService("other", "zone-b"), false)
+	assert.Equal(t, 0, controller.queue.Len())
+
+	controller.enqueue(newService("own", "zone-a"), false)
+	assert.Equal(t, 1, controller.queue.Len())
+	key, _ := controller.queue.Get()
+	assert.Equal(t, "test/own", key)
+}
+
+func TestServiceParkingDeleted(t *testing.T) {
+	cfg := &config.Config{PublicPool: "internet", MaxRetries: 1}
+	controller := newMemoryController(cfg, k8sutil.NewMemoryAllocator())
+
+	// The Service was deleted before it could be parked.
+	controller.queue.AddRateLimited("test/svc")
+	assert.Nil(t, controller.retry("test/svc", fmt.Errorf("failed")))
+	assert.Equal(t, 0, controller.queue.NumRequeues("test/svc"))
+	assert.Equal(t, 0, controller.parker.Len())
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// migrationCheckPeriod is the period of checking whether the public IP of the new pool is active.
+const migrationCheckPeriod = time.Second * 10
+
+// migrationIPName returns the name of the public IP object of the new pool.
+func migrationIPName(svc *v1.Service, pool string) string {
+	return fmt.Sprintf("%s-%s", svc.Spec.ExternalIPs[0], pool)
+}
+
+// migrate moves the public IP of the Service to the pool of the migration annotation. The IP of
+// the new pool is allocated first, both IPs are published for the drain period, and then the old
+// one is released. It returns how long to wait for the next step.
+func (c *Controller) migrate(svc *v1.Service) (time.Duration, error) {
+	pool := svc.Annotations[c.keys.MigrateToPoolKey]
+	if pool == "" || pool == svc.Annotations[c.keys.PublicPoolKey] {
+		// The migration was cancelled.
+		if err := c.releaseMigrationIP(svc); err != nil {
+			return 0, err
+		}
+		c.clearMigration(svc)
+		return 0, nil
+	}
+
+	name := svc.Annotations[c.keys.MigrationPublicIPRefKey]
+	if name == "" {
+		// The public IP cannot be moved while other Services use it.
+		shared, err := c.inUse(svc)
+		if err != nil {
+			return 0, err
+		}
+		if shared {
+			c.recorder.Eventf(svc, v1.EventTypeWarning, "MigrationBlocked",
+				"Public IP %s is shared with other Services, and cannot be migrated to pool '%s'", svc.Annotations[c.keys.PublicIPKey], pool)
+			return 0, nil
+		}
+		name = migrationIPName(svc, pool)
+	}
+
+	ip, err := c.allocator.Get(svc.Namespace, name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return 0, err
+		}
+
+		req := &k8sutil.Request{
+			Name:  name,
+			Pool:  pool,
+			Owner: k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: svc.Namespace, Name: svc.Name},
+		}
+		if ip, err = c.allocator.Allocate(req); err != nil {
+			return 0, err
+		}
+		audit.Record(c.sink, c.event(svc, audit.ActionAllocate, ip, fmt.Sprintf("migrating to pool '%s'", pool)))
+		c.recorder.Eventf(svc, v1.EventTypeNormal, "MigrationStarted",
+			"Allocating a public IP from pool '%s' to replace %s", pool, svc.Annotations[c.keys.PublicIPKey])
+	}
+	svc.Annotations[c.keys.MigrationPublicIPRefKey] = name
+
+	if net.ParseIP(ip.Status.Address) == nil {
+		glog.V(2).Infof("Service controller is waiting for the public IP of pool '%s' for '%s/%s'.", pool, svc.Namespace, svc.Name)
+		return migrationCheckPeriod, nil
+	}
+
+	until, err := time.Parse(time.RFC3339, svc.Annotations[c.keys.DrainUntilKey])
+	if err != nil {
+		until = time.Now().Add(c.cfg.MigrationDrainPeriod)
+		svc.Annotations[c.keys.MigrationPublicIPKey] = ip.Status.Address
+		svc.Annotations[c.keys.DrainUntilKey] = until.UTC().Format(time.RFC3339)
+		audit.Record(c.sink, c.event(svc, audit.ActionAssign, ip, fmt.Sprintf("migrating to pool '%s'", pool)))
+		c.recorder.Eventf(svc, v1.EventTypeNormal, "MigrationPublished",
+			"Publishing public IPs %s and %s until %s", svc.Annotations[c.keys.PublicIPKey], ip.Status.Address, svc.Annotations[c.keys.DrainUntilKey])
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait, nil
+	}
+
+	old := svc.Annotations[c.keys.PublicIPKey]
+	if err := c.deallocate(svc, fmt.Sprintf("migrated to pool '%s'", pool)); err != nil {
+		return 0, err
+	}
+
+	svc.Annotations[c.keys.PublicPoolKey] = pool
+	svc.Annotations[c.keys.PublicIPKey] = ip.Status.Address
+	svc.Annotations[c.keys.PublicIPRefKey] = ip.Name
+	c.clearMigration(svc)
+	c.recorder.Eventf(svc, v1.EventTypeNormal, "MigrationCompleted",
+		"Released public IP %s, migrated to %s of pool '%s'", old, ip.Status.Address, pool)
+	return 0, nil
+}
+
+func (c *Controller) clearMigration(svc *v1.Service) {
+	delete(svc.Annotations, c.keys.MigrateToPoolKey)
+	delete(svc.Annotations, c.keys.MigrationPublicIPKey)
+	delete(svc.Annotations, c.keys.MigrationPublicIPRefKey)
+	delete(svc.Annotations, c.keys.DrainUntilKey)
+}
+
+// releaseMigrationIP releases the public IP of the new pool of an unfinished migration.
+func (c *Controller) releaseMigrationIP(svc *v1.Service) error {
+	name := svc.Annotations[c.keys.MigrationPublicIPRefKey]
+	if name == "" {
+		return nil
+	}
+
+	ip, err := c.allocator.Get(svc.Namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if err := c.allocator.Release(ip); err != nil {
+		return err
+	}
+	audit.Record(c.sink, c.event(svc, audit.ActionRelease, ip, "migration cancelled"))
+	return nil
+}
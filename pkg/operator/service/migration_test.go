@@ -0,0 +1,93 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMigrate(t *testing.T) {
+	cfg := &config.Config{PublicPool: "isp1", MigrationDrainPeriod: time.Hour}
+	allocator := k8sutil.NewMemoryAllocator(
+		&blendedv1.Pool{
+			ObjectMeta: metav1.ObjectMeta{Name: "isp1"},
+			Spec:       blendedv1.PoolSpec{Addresses: []string{"140.11.22.33-140.11.22.40"}},
+		},
+		&blendedv1.Pool{
+			ObjectMeta: metav1.ObjectMeta{Name: "isp2"},
+			Spec:       blendedv1.PoolSpec{Addresses: []string{"150.11.22.33-150.11.22.40"}},
+		},
+	)
+	controller := newMemoryController(cfg, allocator)
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-svc", Namespace: "test"},
+		Spec:       corev1.ServiceSpec{ExternalIPs: []string{"172.22.132.100"}},
+	}
+	controller.makeDefaultPool(svc)
+	assert.NotNil(t, controller.allocate(svc))
+	assert.Nil(t, controller.allocate(svc))
+	assert.Equal(t, "140.11.22.33", svc.Annotations[constants.PublicIPKey])
+
+	// Both public IPs are published during the drain period.
+	svc.Annotations[constants.MigrateToPoolKey] = "isp2"
+	wait, err := controller.migrate(svc)
+	assert.Nil(t, err)
+	assert.True(t, wait > 0)
+	assert.Equal(t, "140.11.22.33", svc.Annotations[constants.PublicIPKey])
+	assert.Equal(t, "150.11.22.33", svc.Annotations[constants.MigrationPublicIPKey])
+	assert.Equal(t, "172.22.132.100-isp2", svc.Annotations[constants.MigrationPublicIPRefKey])
+
+	// The old public IP is released after the drain period.
+	svc.Annotations[constants.DrainUntilKey] = time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+	wait, err = controller.migrate(svc)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+	assert.Equal(t, "isp2", svc.Annotations[constants.PublicPoolKey])
+	assert.Equal(t, "150.11.22.33", svc.Annotations[constants.PublicIPKey])
+	assert.Equal(t, "172.22.132.100-isp2", svc.Annotations[constants.PublicIPRefKey])
+	assert.Empty(t, svc.Annotations[constants.MigrateToPoolKey])
+	assert.Empty(t, svc.Annotations[constants.MigrationPublicIPKey])
+
+	ips, err := allocator.List(svc.Namespace)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 1)
+	assert.Equal(t, "isp2", ips.Items[0].Spec.PoolName)
+
+	// A cancelled migration releases the public IP of the new pool.
+	svc.Annotations[constants.MigrateToPoolKey] = "isp1"
+	_, err = controller.migrate(svc)
+	assert.Nil(t, err)
+	delete(svc.Annotations, constants.MigrateToPoolKey)
+	_, err = controller.migrate(svc)
+	assert.Nil(t, err)
+	assert.Equal(t, "150.11.22.33", svc.Annotations[constants.PublicIPKey])
+	assert.Empty(t, svc.Annotations[constants.MigrationPublicIPRefKey])
+
+	ips, err = allocator.List(svc.Namespace)
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 1)
+}
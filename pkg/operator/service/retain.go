@@ -0,0 +1,202 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const retainCheckPeriod = time.Minute
+
+// parseRetainPolicy parses the retain policy, the period is zero when the IP is retained forever.
+func parseRetainPolicy(value string) (string, time.Duration, error) {
+	switch {
+	case value == "" || value == constants.RetainPolicyDelete:
+		return constants.RetainPolicyDelete, 0, nil
+	case value == constants.RetainPolicyRetain:
+		return constants.RetainPolicyRetain, 0, nil
+	case strings.HasPrefix(value, constants.RetainPolicyRetainFor+"="):
+		period, err := time.ParseDuration(strings.TrimPrefix(value, constants.RetainPolicyRetainFor+"="))
+		if err != nil || period <= 0 {
+			return "", 0, fmt.Errorf("invalid retain period in '%s'", value)
+		}
+		return constants.RetainPolicyRetainFor, period, nil
+	}
+	return "", 0, fmt.Errorf("unknown retain policy '%s'", value)
+}
+
+// RetainPeriod returns the retain period of a deleted Service with the annotations, zero retains the IP
+// until the Service is re-created. It is false when the IP is released with the Service.
+func RetainPeriod(keys *constants.Keys, annotations map[string]string) (time.Duration, bool) {
+	policy, period, err := parseRetainPolicy(annotations[keys.RetainPolicyKey])
+	if err != nil {
+		// An unclear policy retains the IP like the cleanup of a Service.
+		return 0, true
+	}
+	return period, policy != constants.RetainPolicyDelete
+}
+
+// MarkRetained marks the IP as retained for the claim key, a positive period sets when the IP is released.
+func MarkRetained(keys *constants.Keys, ip *blendedv1.IP, claim string, period time.Duration) {
+	if ip.Labels == nil {
+		ip.Labels = map[string]string{}
+	}
+	if ip.Annotations == nil {
+		ip.Annotations = map[string]string{}
+	}
+
+	ip.Labels[keys.RetainedLabel] = "true"
+	ip.Annotations[keys.RetainedClaimKey] = claim
+	delete(ip.Annotations, keys.RetainUntilKey)
+	if period > 0 {
+		ip.Annotations[keys.RetainUntilKey] = time.Now().Add(period).UTC().Format(time.RFC3339)
+	}
+}
+
+func (c *Controller) claimKey(svc *v1.Service) string {
+	if key := svc.Annotations[c.keys.ClaimKey]; key != "" {
+		return key
+	}
+	return svc.Name
+}
+
+// retain keeps the public IP of a deleted Service reserved for its claim key.
+func (c *Controller) retain(svc *v1.Service, period time.Duration) error {
+	namespace, name := IPRef(c.keys, svc)
+	ip, err := c.allocator.Get(namespace, name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Only the retention markers are patched, so that a concurrent change of the IP is not overwritten.
+	ipCopy := ip.DeepCopy()
+	MarkRetained(c.keys, ipCopy, c.claimKey(svc), period)
+	updated, err := c.allocator.Patch(ip, ipCopy)
+	if err != nil {
+		return err
+	}
+	audit.Record(c.sink, c.event(svc, audit.ActionRetain, updated, fmt.Sprintf("retained for claim '%s'", c.claimKey(svc))))
+	glog.V(2).Infof("Service controller retained IP '%s' for claim '%s'.", ip.Status.Address, c.claimKey(svc))
+	return nil
+}
+
+// reattach returns the IP which was retained for the claim key of the Service, and takes it over.
+func (c *Controller) reattach(svc *v1.Service) (*blendedv1.IP, error) {
+	ips, err := c.allocator.List(svc.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	key := c.claimKey(svc)
+	pool := svc.Annotations[c.keys.PublicPoolKey]
+	for _, ip := range ips.Items {
+		if ip.Labels[c.keys.RetainedLabel] != "true" || ip.Annotations[c.keys.RetainedClaimKey] != key {
+			continue
+		}
+		if ip.Spec.PoolName != pool || net.ParseIP(ip.Status.Address) == nil {
+			continue
+		}
+
+		return c.rebind(svc, &ip)
+	}
+	return nil, nil
+}
+
+// rebind takes over the retained IP for the Service. The retention markers are cleared by a patch, so that the
+// retention sweeper does not release the IP once it is in use.
+func (c *Controller) rebind(svc *v1.Service, ip *blendedv1.IP) (*blendedv1.IP, error) {
+	if key := ip.Annotations[c.keys.RetainedClaimKey]; key != c.claimKey(svc) {
+		// Retrying does not help, the Service is parked until its annotations change.
+		c.recorder.Eventf(svc, v1.EventTypeWarning, "ClaimKeyMismatch",
+			"IP '%s' is retained for claim '%s', set the %s annotation to it to take the IP over", ip.Name, key, c.keys.ClaimKey)
+		return nil, k8sutil.Permanent(fmt.Errorf("IP '%s' is retained for claim '%s', not for '%s'", ip.Name, key, c.claimKey(svc)))
+	}
+
+	ipCopy := ip.DeepCopy()
+	delete(ipCopy.Labels, c.keys.RetainedLabel)
+	delete(ipCopy.Annotations, c.keys.RetainedClaimKey)
+	delete(ipCopy.Annotations, c.keys.RetainUntilKey)
+	owner := k8sutil.Owner{Kind: constants.OwnerKindService, Namespace: svc.Namespace, Name: svc.Name}
+	for k, v := range k8sutil.OwnerLabels(c.keys, owner) {
+		ipCopy.Labels[k] = v
+	}
+
+	updated, err := c.allocator.Patch(ip, ipCopy)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(2).Infof("Service controller re-attached IP '%s' to '%s/%s'.", updated.Status.Address, svc.Namespace, svc.Name)
+	return updated, nil
+}
+
+// releaseExpiredIPs releases the retained IPs whose retain period has passed.
+func (c *Controller) releaseExpiredIPs() {
+	for _, namespace := range c.cfg.Namespaces() {
+		c.releaseExpiredIPsOf(namespace)
+	}
+}
+
+// releaseExpiredIPsOf releases the expired retained IPs of the namespace.
+func (c *Controller) releaseExpiredIPsOf(namespace string) {
+	ips, err := c.allocator.List(namespace)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	for _, ip := range ips.Items {
+		if ip.Labels[c.keys.RetainedLabel] != "true" {
+			continue
+		}
+
+		until, err := time.Parse(time.RFC3339, ip.Annotations[c.keys.RetainUntilKey])
+		if err != nil || time.Now().Before(until) {
+			continue
+		}
+
+		if err := c.allocator.Release(&ip); err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		audit.Record(c.sink, &audit.Event{
+			Action:    audit.ActionRelease,
+			OwnerKind: ip.Labels[c.keys.OwnerKindLabel],
+			Namespace: ip.Namespace,
+			Name:      ip.Labels[c.keys.OwnerNameLabel],
+			Pool:      ip.Spec.PoolName,
+			IP:        ip.Name,
+			Address:   ip.Status.Address,
+			Reason:    "retain period expired",
+		})
+		glog.V(2).Infof("Service controller released the expired retained IP '%s'.", ip.Status.Address)
+	}
+}
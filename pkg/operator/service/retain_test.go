@@ -0,0 +1,167 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseRetainPolicy(t *testing.T) {
+	tests := []struct {
+		value  string
+		policy string
+		period time.Duration
+		err    bool
+	}{
+		{value: "", policy: constants.RetainPolicyDelete},
+		{value: "Delete", policy: constants.RetainPolicyDelete},
+		{value: "Retain", policy: constants.RetainPolicyRetain},
+		{value: "RetainFor=24h", policy: constants.RetainPolicyRetainFor, period: 24 * time.Hour},
+		{value: "RetainFor=forever", err: true},
+		{value: "Keep", err: true},
+	}
+
+	for _, test := range tests {
+		policy, period, err := parseRetainPolicy(test.value)
+		if test.err {
+			assert.NotNil(t, err, test.value)
+			continue
+		}
+		assert.Nil(t, err, test.value)
+		assert.Equal(t, test.policy, policy, test.value)
+		assert.Equal(t, test.period, period, test.value)
+	}
+}
+
+func newMemoryController(cfg *config.Config, allocator k8sutil.Allocator) *Controller {
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	return NewController(cfg, clientset, allocator, nil, informer.Core().V1().Namespaces(), informer.Core().V1().Services())
+}
+
+func TestRetainAndReattach(t *testing.T) {
+	cfg := &config.Config{PublicPool: "internet"}
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: cfg.PublicPool},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"140.11.22.33-140.11.22.40"}},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	controller := newMemoryController(cfg, allocator)
+
+	newService := func(externalIP string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-svc",
+				Namespace: "test",
+				Annotations: map[string]string{
+					constants.RetainPolicyKey: "RetainFor=1h",
+				},
+			},
+			Spec: corev1.ServiceSpec{ExternalIPs: []string{externalIP}},
+		}
+	}
+
+	svc := newService("172.11.22.33")
+	controller.makeDefaultPool(svc)
+	assert.NotNil(t, controller.allocate(svc))
+	assert.Nil(t, controller.allocate(svc))
+	assert.Equal(t, "140.11.22.33", svc.Annotations[constants.PublicIPKey])
+	assert.Equal(t, "172.11.22.33", svc.Annotations[constants.PublicIPRefKey])
+
+	assert.Nil(t, controller.retain(svc, time.Hour))
+	ip, err := allocator.Get("test", "172.11.22.33")
+	assert.Nil(t, err)
+	assert.Equal(t, "true", ip.Labels[constants.RetainedLabel])
+	assert.Equal(t, "test-svc", ip.Annotations[constants.RetainedClaimKey])
+
+	// A Service with the same name gets the same public IP back.
+	recreated := newService("172.11.22.34")
+	controller.makeDefaultPool(recreated)
+	assert.Nil(t, controller.allocate(recreated))
+	assert.Equal(t, "140.11.22.33", recreated.Annotations[constants.PublicIPKey])
+	assert.Equal(t, "172.11.22.33", recreated.Annotations[constants.PublicIPRefKey])
+
+	ip, err = allocator.Get("test", "172.11.22.33")
+	assert.Nil(t, err)
+	assert.Equal(t, "", ip.Labels[constants.RetainedLabel])
+
+	// Expired retained IPs are released.
+	assert.Nil(t, controller.retain(recreated, time.Hour))
+	ip, err = allocator.Get("test", "172.11.22.33")
+	assert.Nil(t, err)
+	ip.Annotations[constants.RetainUntilKey] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	_, err = allocator.Update(ip)
+	assert.Nil(t, err)
+
+	controller.releaseExpiredIPs()
+	ips, err := allocator.List("test")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(ips.Items))
+}
+
+func TestRebindRetainedIP(t *testing.T) {
+	cfg := &config.Config{PublicPool: "internet"}
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: cfg.PublicPool},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"140.11.22.33-140.11.22.40"}},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	controller := newMemoryController(cfg, allocator)
+
+	ip, err := allocator.Allocate(&k8sutil.Request{Name: "172.11.22.33", Pool: pool.Name, Owner: k8sutil.Owner{Namespace: "test"}})
+	assert.Nil(t, err)
+	MarkRetained(constants.DefaultKeys, ip, "other", time.Hour)
+	_, err = allocator.Update(ip)
+	assert.Nil(t, err)
+
+	// A Service of another claim does not take over the IP of its external IP.
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-svc",
+			Namespace:   "test",
+			Annotations: map[string]string{constants.PublicIPRefKey: "172.11.22.33"},
+		},
+		Spec: corev1.ServiceSpec{ExternalIPs: []string{"172.11.22.33"}},
+	}
+	controller.makeDefaultPool(svc)
+	err = controller.allocate(svc)
+	assert.True(t, k8sutil.IsPermanent(err))
+	assert.Empty(t, svc.Annotations[constants.PublicIPKey])
+
+	// The Service of the claim takes it over, and the retention markers are cleared.
+	svc.Annotations[constants.ClaimKey] = "other"
+	assert.Nil(t, controller.allocate(svc))
+	assert.Equal(t, "140.11.22.33", svc.Annotations[constants.PublicIPKey])
+
+	ip, err = allocator.Get("test", "172.11.22.33")
+	assert.Nil(t, err)
+	assert.Empty(t, ip.Labels[constants.RetainedLabel])
+	assert.Empty(t, ip.Annotations[constants.RetainedClaimKey])
+	assert.Empty(t, ip.Annotations[constants.RetainUntilKey])
+	assert.Equal(t, "test-svc", ip.Labels[constants.OwnerNameLabel])
+}
@@ -0,0 +1,252 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/inwinstack/ip-assigner/pkg/audit"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var errSharingConflict = fmt.Errorf("the ports conflict with other Services of the sharing key")
+
+// IPRef returns the namespace and name of the public IP object of the Service. The
+// reference is "<name>" for the namespace of the Service, or "<namespace>/<name>" for
+// an IP which is shared across namespaces.
+func IPRef(keys *constants.Keys, svc *v1.Service) (string, string) {
+	if ref := svc.Annotations[keys.PublicIPRefKey]; ref != "" {
+		if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 {
+			return parts[0], parts[1]
+		}
+		return svc.Namespace, ref
+	}
+
+	if len(svc.Spec.ExternalIPs) == 0 {
+		return svc.Namespace, ""
+	}
+	// Sharers which are reconciled together all miss each other in the cache, so they allocate
+	// one IP named after the key and pool. The later creates fail, and the Services join it on a retry.
+	if key := svc.Annotations[keys.SharingKey]; key != "" {
+		return svc.Namespace, sharedIPName(key, svc.Annotations[keys.PublicPoolKey])
+	}
+	return svc.Namespace, svc.Spec.ExternalIPs[0]
+}
+
+// sharedIPName returns the name of the public IP which is allocated for the sharing key from the pool.
+func sharedIPName(key, pool string) string {
+	return fmt.Sprintf("shared-%s-%s", key, pool)
+}
+
+func protocol(port v1.ServicePort) v1.Protocol {
+	if port.Protocol == "" {
+		return v1.ProtocolTCP
+	}
+	return port.Protocol
+}
+
+// conflictingPort returns the port of a that is also used by b.
+func conflictingPort(a, b *v1.Service) *v1.ServicePort {
+	for i, p := range a.Spec.Ports {
+		for _, q := range b.Spec.Ports {
+			if p.Port == q.Port && protocol(p) == protocol(q) {
+				return &a.Spec.Ports[i]
+			}
+		}
+	}
+	return nil
+}
+
+// allowsCrossNamespaceSharing reports whether an admin approved the namespace to share public IPs with other namespaces.
+func (c *Controller) allowsCrossNamespaceSharing(name string) (bool, error) {
+	// The Namespaces cannot be read when watching namespaces.
+	if c.cfg.Namespaced() || c.nsLister == nil {
+		return false, nil
+	}
+
+	ns, err := c.nsLister.Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return ns.Annotations[c.keys.AllowCrossNamespaceSharingKey] == "true", nil
+}
+
+// sharers returns the Services which have the same sharing key and an allocated public IP, the oldest first.
+func (c *Controller) sharers(svc *v1.Service) ([]*v1.Service, error) {
+	key := svc.Annotations[c.keys.SharingKey]
+	crossNamespace, err := c.allowsCrossNamespaceSharing(svc.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	svcs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var sharers []*v1.Service
+	for _, s := range svcs {
+		if s.Namespace == svc.Namespace && s.Name == svc.Name {
+			continue
+		}
+		if s.Annotations[c.keys.SharingKey] != key || !s.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if s.Annotations[c.keys.PublicPoolKey] != svc.Annotations[c.keys.PublicPoolKey] {
+			continue
+		}
+		if net.ParseIP(s.Annotations[c.keys.PublicIPKey]) == nil {
+			continue
+		}
+
+		if s.Namespace != svc.Namespace {
+			if !crossNamespace {
+				continue
+			}
+			allowed, err := c.allowsCrossNamespaceSharing(s.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			if !allowed {
+				continue
+			}
+		}
+		sharers = append(sharers, s)
+	}
+
+	sort.Slice(sharers, func(i, j int) bool {
+		return sharers[i].CreationTimestamp.Before(&sharers[j].CreationTimestamp)
+	})
+	return sharers, nil
+}
+
+// share assigns the public IP of the other Services with the same sharing key. It
+// returns false when the Service is the first one of the sharing key.
+func (c *Controller) share(svc *v1.Service) (bool, error) {
+	sharers, err := c.sharers(svc)
+	if err != nil {
+		return false, err
+	}
+	if len(sharers) == 0 {
+		return false, nil
+	}
+
+	key := svc.Annotations[c.keys.SharingKey]
+	for _, s := range sharers {
+		if port := conflictingPort(svc, s); port != nil {
+			c.recorder.Eventf(svc, v1.EventTypeWarning, "SharingConflict",
+				"Port %d/%s is already used by Service %s/%s with sharing key '%s'", port.Port, protocol(*port), s.Namespace, s.Name, key)
+			return false, errSharingConflict
+		}
+	}
+
+	owner := sharers[0]
+	namespace, name := IPRef(c.keys, owner)
+	ref := name
+	if namespace != svc.Namespace {
+		ref = fmt.Sprintf("%s/%s", namespace, name)
+	}
+
+	svc.Annotations[c.keys.PublicIPKey] = owner.Annotations[c.keys.PublicIPKey]
+	svc.Annotations[c.keys.PublicIPRefKey] = ref
+	audit.Record(c.sink, c.event(svc, audit.ActionAssign, nil, fmt.Sprintf("shared by sharing key '%s'", key)))
+	c.recorder.Eventf(svc, v1.EventTypeNormal, "SharedPublicIP",
+		"Sharing public IP %s with Service %s/%s by sharing key '%s'", owner.Annotations[c.keys.PublicIPKey], owner.Namespace, owner.Name, key)
+	return true, nil
+}
+
+// checkSharing checks the Services which share the public IP of the Service again, since their ports
+// may have been changed after they joined. A conflict is reported on the newer Service of a pair, and
+// errSharingConflict is returned when the Service itself is the newer one.
+func (c *Controller) checkSharing(svc *v1.Service) error {
+	svcs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	key := svc.Annotations[c.keys.SharingKey]
+	namespace, name := IPRef(c.keys, svc)
+	var conflict error
+	for _, s := range svcs {
+		if s.Namespace == svc.Namespace && s.Name == svc.Name {
+			continue
+		}
+		if s.Annotations[c.keys.SharingKey] != key || !s.ObjectMeta.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if ns, n := IPRef(c.keys, s); ns != namespace || n != name {
+			continue
+		}
+
+		newer, older := svc, s
+		if svc.CreationTimestamp.Before(&s.CreationTimestamp) {
+			newer, older = s, svc
+		}
+
+		if pool := newer.Annotations[c.keys.PublicPoolKey]; pool != older.Annotations[c.keys.PublicPoolKey] {
+			c.recorder.Eventf(newer, v1.EventTypeWarning, "SharingConflict",
+				"Pool '%s' differs from pool '%s' of Service %s/%s with sharing key '%s'",
+				pool, older.Annotations[c.keys.PublicPoolKey], older.Namespace, older.Name, key)
+		} else if port := conflictingPort(newer, older); port != nil {
+			c.recorder.Eventf(newer, v1.EventTypeWarning, "SharingConflict",
+				"Port %d/%s is already used by Service %s/%s with sharing key '%s'", port.Port, protocol(*port), older.Namespace, older.Name, key)
+		} else {
+			continue
+		}
+
+		if newer == svc {
+			conflict = errSharingConflict
+		}
+	}
+	return conflict
+}
+
+// inUse reports whether other Services still use the public IP of the Service.
+func (c *Controller) inUse(svc *v1.Service) (bool, error) {
+	svcs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return false, err
+	}
+
+	namespace, name := IPRef(c.keys, svc)
+	address := svc.Annotations[c.keys.PublicIPKey]
+	for _, s := range svcs {
+		if s.Namespace == svc.Namespace && s.Name == svc.Name {
+			continue
+		}
+		if !s.ObjectMeta.DeletionTimestamp.IsZero() || s.Annotations[c.keys.PublicIPKey] == "" {
+			continue
+		}
+
+		if s.Namespace == svc.Namespace && s.Annotations[c.keys.PublicIPKey] == address {
+			return true, nil
+		}
+		if ns, n := IPRef(c.keys, s); ns == namespace && n == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,194 @@
+/*
+Copyright © 2018 inwinSTACK Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	blendedv1 "github.com/inwinstack/blended/apis/inwinstack/v1"
+	"github.com/inwinstack/ip-assigner/pkg/config"
+	"github.com/inwinstack/ip-assigner/pkg/constants"
+	"github.com/inwinstack/ip-assigner/pkg/k8sutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newSharingService(namespace, name, externalIP string, port int32) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				constants.SharingKey: "web",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ExternalIPs: []string{externalIP},
+			Ports:       []corev1.ServicePort{{Port: port, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+}
+
+func TestSharingKey(t *testing.T) {
+	cfg := &config.Config{PublicPool: "internet"}
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: cfg.PublicPool},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"140.11.22.33-140.11.22.40"}},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	indexer := informer.Core().V1().Services().Informer().GetIndexer()
+	nsIndexer := informer.Core().V1().Namespaces().Informer().GetIndexer()
+	controller := NewController(cfg, clientset, allocator, nil, informer.Core().V1().Namespaces(), informer.Core().V1().Services())
+
+	for _, name := range []string{"test1", "test2"} {
+		assert.Nil(t, nsIndexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+
+	http := newSharingService("test1", "http", "172.11.22.33", 80)
+	controller.makeDefaultPool(http)
+	assert.NotNil(t, controller.allocate(http))
+	assert.Nil(t, controller.allocate(http))
+	assert.Equal(t, "140.11.22.33", http.Annotations[constants.PublicIPKey])
+	assert.Nil(t, indexer.Add(http))
+
+	// The same sharing key gets the same public IP.
+	https := newSharingService("test1", "https", "172.11.22.34", 443)
+	controller.makeDefaultPool(https)
+	assert.Nil(t, controller.allocate(https))
+	assert.Equal(t, "140.11.22.33", https.Annotations[constants.PublicIPKey])
+	assert.Equal(t, "shared-web-internet", https.Annotations[constants.PublicIPRefKey])
+	assert.Nil(t, indexer.Add(https))
+
+	// The port is already used.
+	conflict := newSharingService("test1", "conflict", "172.11.22.35", 80)
+	controller.makeDefaultPool(conflict)
+	assert.Equal(t, errSharingConflict, controller.allocate(conflict))
+	assert.Equal(t, "", conflict.Annotations[constants.PublicIPKey])
+
+	// Other namespaces cannot share without approval.
+	other := newSharingService("test2", "other", "172.11.22.36", 8080)
+	controller.makeDefaultPool(other)
+	shared, err := controller.share(other)
+	assert.Nil(t, err)
+	assert.False(t, shared)
+
+	for _, name := range []string{"test1", "test2"} {
+		assert.Nil(t, nsIndexer.Update(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{constants.AllowCrossNamespaceSharingKey: "true"},
+		}}))
+	}
+
+	shared, err = controller.share(other)
+	assert.Nil(t, err)
+	assert.True(t, shared)
+	assert.Equal(t, "test1/shared-web-internet", other.Annotations[constants.PublicIPRefKey])
+
+	// The IP is released only by the last sharer.
+	inUse, err := controller.inUse(http)
+	assert.Nil(t, err)
+	assert.True(t, inUse)
+
+	assert.Nil(t, indexer.Delete(https))
+	inUse, err = controller.inUse(http)
+	assert.Nil(t, err)
+	assert.False(t, inUse)
+}
+
+func TestSharingKeyReconciledTogether(t *testing.T) {
+	cfg := &config.Config{PublicPool: "internet"}
+	pool := &blendedv1.Pool{
+		ObjectMeta: metav1.ObjectMeta{Name: cfg.PublicPool},
+		Spec:       blendedv1.PoolSpec{Addresses: []string{"140.11.22.33-140.11.22.40"}},
+	}
+	allocator := k8sutil.NewMemoryAllocator(pool)
+	controller := newMemoryController(cfg, allocator)
+
+	// Neither Service is in the cache of the other, both use the IP of the sharing key.
+	http := newSharingService("test", "http", "172.11.22.33", 80)
+	https := newSharingService("test", "https", "172.11.22.34", 443)
+	controller.makeDefaultPool(http)
+	controller.makeDefaultPool(https)
+	assert.NotNil(t, controller.allocate(http))
+	assert.Nil(t, controller.allocate(https))
+	assert.Nil(t, controller.allocate(http))
+	assert.Equal(t, "140.11.22.33", http.Annotations[constants.PublicIPKey])
+	assert.Equal(t, "140.11.22.33", https.Annotations[constants.PublicIPKey])
+
+	ips, err := allocator.List("test")
+	assert.Nil(t, err)
+	assert.Len(t, ips.Items, 1)
+	assert.Equal(t, "shared-web-internet", ips.Items[0].Name)
+}
+
+func TestSharingKeyWatchingNamespaces(t *testing.T) {
+	cfg := &config.Config{WatchNamespaces: []string{"test1"}}
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	controller := NewController(cfg, clientset, k8sutil.NewMemoryAllocator(), nil, informer.Core().V1().Namespaces(), informer.Core().V1().Services())
+
+	// The Namespace is not read, so an approval is ignored.
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "test1",
+		Annotations: map[string]string{constants.AllowCrossNamespaceSharingKey: "true"},
+	}}
+	_, err := clientset.CoreV1().Namespaces().Create(ns)
+	assert.Nil(t, err)
+
+	allowed, err := controller.allowsCrossNamespaceSharing("test1")
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+}
+
+func TestCheckSharing(t *testing.T) {
+	cfg := &config.Config{PublicPool: "internet"}
+	clientset := fake.NewSimpleClientset()
+	informer := informers.NewSharedInformerFactory(clientset, 0)
+	indexer := informer.Core().V1().Services().Informer().GetIndexer()
+	controller := NewController(cfg, clientset, k8sutil.NewMemoryAllocator(), nil, informer.Core().V1().Namespaces(), informer.Core().V1().Services())
+
+	http := newSharingService("test", "http", "172.11.22.33", 80)
+	http.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+	https := newSharingService("test", "https", "172.11.22.34", 443)
+	https.CreationTimestamp = metav1.Now()
+	for _, svc := range []*corev1.Service{http, https} {
+		controller.makeDefaultPool(svc)
+		svc.Annotations[constants.PublicIPKey] = "140.11.22.33"
+		svc.Annotations[constants.PublicIPRefKey] = "172.11.22.33"
+		assert.Nil(t, indexer.Add(svc))
+	}
+	assert.Nil(t, controller.checkSharing(http))
+	assert.Nil(t, controller.checkSharing(https))
+
+	// The older Service is changed to the port of the newer one, which gets the conflict.
+	http.Spec.Ports[0].Port = 443
+	assert.Nil(t, indexer.Update(http))
+	assert.Nil(t, controller.checkSharing(http))
+	assert.Equal(t, errSharingConflict, controller.checkSharing(https))
+
+	// A sharer of another pool conflicts as well.
+	http.Spec.Ports[0].Port = 80
+	http.Annotations[constants.PublicPoolKey] = "other"
+	assert.Nil(t, indexer.Update(http))
+	assert.Equal(t, errSharingConflict, controller.checkSharing(https))
+}